@@ -0,0 +1,20 @@
+package blob
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+/**
+ * Storage keeps the encrypted archives of the file shares.
+ */
+type Storage interface {
+	// Create returns a writer for a new blob, it's stored once the writer is closed
+	Create(name string) (io.WriteCloser, error)
+	// Open returns a reader of the blob content, ErrNotFound if it doesn't exist
+	Open(name string) (io.ReadCloser, error)
+	// Remove deletes the blob
+	Remove(name string) error
+}
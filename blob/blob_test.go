@@ -0,0 +1,47 @@
+package blob
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+/**
+ * What every storage must do: store a blob once its writer is closed, read it back and remove it.
+ */
+func testStorage(t *testing.T, s Storage, content []byte) {
+	w, err := s.Create("blob.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := s.Open("blob.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Open read %d bytes, want the %d written", len(got), len(content))
+	}
+
+	if _, err := s.Open("missing.zip"); err != ErrNotFound {
+		t.Errorf("Open(missing) err = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Remove("blob.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open("blob.zip"); err != ErrNotFound {
+		t.Errorf("Open after Remove err = %v, want ErrNotFound", err)
+	}
+}
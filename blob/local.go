@@ -0,0 +1,42 @@
+package blob
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/**
+ * Local disk storage, blobs are files of the folder.
+ */
+type Local struct {
+	folder string
+}
+
+func NewLocal(folder string) *Local {
+	return &Local{folder: folder}
+}
+
+func (l *Local) path(name string) string {
+	return filepath.Join(l.folder, filepath.Base(name))
+}
+
+func (l *Local) Create(name string) (io.WriteCloser, error) {
+	return os.OpenFile(l.path(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+func (l *Local) Open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (l *Local) Remove(name string) error {
+	err := os.Remove(l.path(name))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
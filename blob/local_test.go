@@ -0,0 +1,72 @@
+package blob
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	testStorage(t, NewLocal(t.TempDir()), []byte("encrypted archive"))
+}
+
+func TestLocalCreateExisting(t *testing.T) {
+	l := NewLocal(t.TempDir())
+	w, err := l.Create("blob.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "first")
+	w.Close()
+
+	if _, err := l.Create("blob.zip"); err == nil {
+		t.Fatal("Create of an existing blob must fail, it would overwrite another share")
+	}
+}
+
+func TestLocalStaysInFolder(t *testing.T) {
+	root := t.TempDir()
+	folder := filepath.Join(root, "files")
+	if err := os.Mkdir(folder, 0700); err != nil {
+		t.Fatal(err)
+	}
+	l := NewLocal(folder)
+
+	w, err := l.Create("../escaped.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if _, err := os.Stat(filepath.Join(root, "escaped.zip")); !os.IsNotExist(err) {
+		t.Error("Create wrote outside of the folder")
+	}
+	if _, err := os.Stat(filepath.Join(folder, "escaped.zip")); err != nil {
+		t.Errorf("Create didn't write in the folder : %v", err)
+	}
+}
+
+func TestLocalRemoveMissing(t *testing.T) {
+	l := NewLocal(t.TempDir())
+	if err := l.Remove("missing.zip"); err != ErrNotFound {
+		t.Errorf("Remove(missing) err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalFileMode(t *testing.T) {
+	folder := t.TempDir()
+	w, err := NewLocal(folder).Create("blob.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	info, err := os.Stat(filepath.Join(folder, "blob.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("blob mode = %v, want 0600", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,109 @@
+package blob
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+)
+
+// Size of the parts sent while the size of the blob is still unknown, that's the memory used by each upload
+const s3PartSize = 16 * 1024 * 1024
+
+type S3Options struct {
+	Endpoint  string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Region    string
+	UseSSL    bool
+	PathStyle bool
+}
+
+/**
+ * S3 compatible storage (AWS, MinIO, ...), several Okuru instances can share the same bucket.
+ */
+type S3 struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3(options S3Options) (*S3, error) {
+	lookup := minio.BucketLookupAuto
+	if options.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(options.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(options.AccessKey, options.SecretKey, ""),
+		Secure:       options.UseSSL,
+		Region:       options.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3{client: client, bucket: options.Bucket}, nil
+}
+
+/**
+ * Check that the bucket is reachable
+ */
+func (s *S3) Ping(ctx context.Context) error {
+	_, err := s.client.BucketExists(ctx, s.bucket)
+	return err
+}
+
+type s3Writer struct {
+	*io.PipeWriter
+	result chan error
+}
+
+/**
+ * Close the pipe and wait for the upload to finish
+ */
+func (w *s3Writer) Close() error {
+	if err := w.PipeWriter.Close(); err != nil {
+		return err
+	}
+	return <-w.result
+}
+
+func (s *S3) Create(name string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	w := &s3Writer{PipeWriter: pw, result: make(chan error, 1)}
+
+	go func() {
+		_, err := s.client.PutObject(context.Background(), s.bucket, name, pr, -1, minio.PutObjectOptions{
+			ContentType: "application/octet-stream",
+			PartSize:    s3PartSize,
+		})
+		// Unblock the writer if the upload failed before reading everything
+		pr.CloseWithError(err)
+		w.result <- err
+	}()
+
+	return w, nil
+}
+
+func (s *S3) Open(name string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(context.Background(), s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// GetObject is lazy, Stat makes sure the blob exists before anything is sent to the client
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return object, nil
+}
+
+func (s *S3) Remove(name string) error {
+	return s.client.RemoveObject(context.Background(), s.bucket, name, minio.RemoveObjectOptions{})
+}
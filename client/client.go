@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+/**
+ * Client of the Okuru API, safe for concurrent use.
+ */
+type Client struct {
+	// Url of the server, with its sub path if any, for example https://okuru.example.com
+	BaseURL string
+	// Defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Sent to create shares on servers requiring an API key, reading a share never needs it
+	ApiKey string
+}
+
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+/**
+ * Send the request and return the response if its status is expected, an *Error otherwise.
+ */
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader, expected int) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.BaseURL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.ApiKey != "" {
+		req.Header.Set("Okuru-Api-Key", c.ApiKey)
+	}
+
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != expected {
+		defer res.Body.Close()
+		return nil, newError(res)
+	}
+	return res, nil
+}
+
+func (c *Client) doJSON(ctx context.Context, method, path, contentType string, body io.Reader, expected int, dest interface{}) error {
+	res, err := c.do(ctx, method, path, contentType, body, expected)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(dest)
+}
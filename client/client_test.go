@@ -0,0 +1,280 @@
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/eraffaelli/Okuru/blob"
+	"github.com/eraffaelli/Okuru/router"
+	"github.com/eraffaelli/Okuru/store"
+	"github.com/eraffaelli/Okuru/utils"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/**
+ * The server looks for its views and public folders next to the executable, link them next to the test binary.
+ */
+func TestMain(m *testing.M) {
+	ex, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+	for _, folder := range []string{"views", "public"} {
+		source, err := filepath.Abs(filepath.Join("..", folder))
+		if err != nil {
+			panic(err)
+		}
+		if err := os.Symlink(source, filepath.Join(filepath.Dir(ex), folder)); err != nil && !os.IsExist(err) {
+			panic(err)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+/**
+ * Start a server with the memory store, configure can change the default configuration first.
+ */
+func newTestClient(t *testing.T, configure func(config *utils.Config)) *Client {
+	config := utils.DefaultConfig()
+	config.Store = "memory"
+	config.NoSsl = true
+	if configure != nil {
+		configure(config)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	limiter, err := utils.NewLimiter(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := router.New(config, utils.Services{
+		Store:   store.NewMemory(),
+		Blobs:   blob.NewLocal(t.TempDir()),
+		Limiter: limiter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(e)
+	t.Cleanup(func() {
+		server.Close()
+		config.Services.Close()
+	})
+	return New(server.URL)
+}
+
+func TestPassword(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t, nil)
+	ctx := context.Background()
+
+	for _, clientEncrypted := range []bool{false, true} {
+		p, err := c.CreatePassword(ctx, PasswordRequest{Password: "hunter2", Views: 2, Deletable: true, ClientEncrypted: clientEncrypted})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Key == "" || !strings.HasSuffix(p.Link, p.Key) {
+			t.Fatalf("CreatePassword key %q, link %q", p.Key, p.Link)
+		}
+
+		info, err := c.GetPassword(ctx, p.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Password != "" || info.ClientEncrypted != clientEncrypted {
+			t.Errorf("GetPassword = %+v", info)
+		}
+		// The GET must not give the ciphertext either, the key of the link is enough to decrypt it
+		storageKey, _ := splitKey(p.Key)
+		response, err := http.Get(c.BaseURL + "/api/v1/" + storageKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if strings.Contains(string(body), `"password"`) {
+			t.Errorf("GET of the share = %s, want no password", body)
+		}
+
+		secret, err := c.RevealPassword(ctx, p.Key, "")
+		if err != nil || secret != "hunter2" {
+			t.Errorf("RevealPassword = %q, %v", secret, err)
+		}
+
+		if err := c.DeletePassword(ctx, p.Key); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.RevealPassword(ctx, p.Key, ""); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RevealPassword after DeletePassword err = %v, want ErrNotFound", err)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t, nil)
+	ctx := context.Background()
+
+	f, err := c.CreateFile(ctx, FileRequest{
+		Files:     []FileUpload{{Name: "notes.txt", Content: strings.NewReader("the notes")}},
+		Password:  "hunter2",
+		Views:     2,
+		Deletable: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Key == "" || f.PasswordLink == "" {
+		t.Fatalf("CreateFile = %+v", f)
+	}
+
+	info, err := c.GetFile(ctx, f.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.PasswordProvided {
+		t.Errorf("GetFile = %+v, the password is needed", info)
+	}
+
+	if _, err := c.DownloadFile(ctx, f.Key, "wrong"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("DownloadFile with a wrong password err = %v, want ErrUnauthorized", err)
+	}
+	archive, err := c.DownloadFile(ctx, f.Key, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(archive)
+	archive.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(z.File) != 1 || z.File[0].Name != "notes.txt" {
+		t.Fatalf("archive files = %v", z.File)
+	}
+	r, err := z.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes, _ := io.ReadAll(r)
+	r.Close()
+	if string(notes) != "the notes" {
+		t.Errorf("notes.txt = %q", notes)
+	}
+
+	if err := c.DeleteFile(ctx, f.Key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetFile(ctx, f.Key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetFile after DeleteFile err = %v, want ErrNotFound", err)
+	}
+}
+
+/**
+ * The web page of a file share doesn't count a view, its download form does, once
+ */
+func TestFilePage(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t, nil)
+	ctx := context.Background()
+
+	f, err := c.CreateFile(ctx, FileRequest{
+		Files:    []FileUpload{{Name: "notes.txt", Content: strings.NewReader("the notes")}},
+		Password: "hunter2",
+		Views:    2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for views := 2; views > 0; views-- {
+		page, err := http.Get(c.BaseURL + "/file/" + f.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page.Body.Close()
+		if page.StatusCode != http.StatusOK {
+			t.Fatalf("file page with %d views left status = %s", views, page.Status)
+		}
+		info, err := c.GetFile(ctx, f.Key)
+		if err != nil || info.Views != views {
+			t.Fatalf("GetFile after the page = %+v, %v, want %d views left", info, err, views)
+		}
+
+		download, err := http.PostForm(c.BaseURL+"/file/"+f.Key, url.Values{"password": {"hunter2"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, download.Body)
+		download.Body.Close()
+		if download.StatusCode != http.StatusOK || download.Header.Get("Content-Type") != "application/zip" {
+			t.Fatalf("download with %d views left = %s %s", views, download.Status, download.Header.Get("Content-Type"))
+		}
+	}
+
+	if _, err := c.GetFile(ctx, f.Key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetFile after the last download err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t, func(config *utils.Config) {
+		config.RateLimitReveal = 3
+	})
+	ctx := context.Background()
+
+	// 400
+	if _, err := c.CreatePassword(ctx, PasswordRequest{Password: "hunter2", TTL: 1 << 30}); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("ttl out of range err = %v, want ErrBadRequest", err)
+	}
+
+	// 401
+	p, err := c.CreatePassword(ctx, PasswordRequest{Password: "hunter2", Passphrase: "open sesame"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeletePassword(ctx, p.Key); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("DeletePassword of a share that isn't deletable err = %v, want ErrUnauthorized", err)
+	}
+	if _, err := c.RevealPassword(ctx, p.Key, "wrong"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("RevealPassword with a wrong passphrase err = %v, want ErrUnauthorized", err)
+	}
+
+	// 403, the test server is reached from 127.0.0.1
+	p, err = c.CreatePassword(ctx, PasswordRequest{Password: "hunter2", AllowedIps: "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RevealPassword(ctx, p.Key, ""); !errors.Is(err, ErrForbidden) {
+		t.Errorf("RevealPassword from outside of allowed_ips err = %v, want ErrForbidden", err)
+	}
+
+	// 404, and the third reveal of the minute
+	if _, err := c.GetPassword(ctx, "00000000-0000-0000-0000-000000000000"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetPassword of a missing share err = %v, want ErrNotFound", err)
+	}
+
+	// 429
+	_, err = c.RevealPassword(ctx, p.Key, "")
+	if !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("RevealPassword over the rate limit err = %v, want ErrTooManyRequests", err)
+	}
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 {
+		t.Errorf("err = %#v, want an *Error with the status", err)
+	}
+}
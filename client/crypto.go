@@ -0,0 +1,60 @@
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+)
+
+/*
+ * Same client encryption as the web page: AES-256-GCM with a random key kept in the link fragment (base64url
+ * without padding), the server gets base64(nonce + ciphertext).
+ */
+
+func encrypt(secret string) (string, string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", "", err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+func decrypt(ciphertext string, encodedKey string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plain), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var (
+	// The share doesn't exist, it expired, was viewed enough times or deleted
+	ErrNotFound = errors.New("okuru: share not found")
+	// Wrong password, or the share isn't deletable
+	ErrUnauthorized = errors.New("okuru: unauthorized")
+	// The share can't be opened from the network of the client
+	ErrForbidden = errors.New("okuru: forbidden")
+	// The request was rejected, see the message of the Error
+	ErrBadRequest = errors.New("okuru: bad request")
+	// The files are bigger than the maximum size of the server
+	ErrTooLarge = errors.New("okuru: upload too large")
+	// Rate limit or quota of the API key reached, retry later
+	ErrTooManyRequests = errors.New("okuru: too many requests")
+	// The client encrypted secret can't be decrypted with the key of the link
+	ErrDecrypt = errors.New("okuru: the secret can't be decrypted with the key of the link")
+)
+
+/**
+ * Error returned by the API, errors.Is matches it with the Err* variable of its status.
+ */
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func newError(res *http.Response) *Error {
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	// The API answers with a JSON string, the middlewares with {"message": ...}, the web routes with text
+	var message string
+	if json.Unmarshal(body, &message) != nil {
+		var object struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &object) == nil && object.Message != "" {
+			message = object.Message
+		} else {
+			message = strings.TrimSpace(string(body))
+		}
+	}
+	return &Error{StatusCode: res.StatusCode, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("okuru: %s (%d %s)", e.Message, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("okuru: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *Error) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusBadRequest:
+		return ErrBadRequest
+	case http.StatusRequestEntityTooLarge:
+		return ErrTooLarge
+	case http.StatusTooManyRequests:
+		return ErrTooManyRequests
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/**
+ * A file of a new file share, Content is read once while uploading.
+ */
+type FileUpload struct {
+	Name    string
+	Content io.Reader
+}
+
+/**
+ * Options of a new file share, zero values use the server defaults.
+ */
+type FileRequest struct {
+	Files []FileUpload
+	// Needed to download the files, a link to retrieve it is returned in PasswordLink
+	Password string
+	// Seconds, within the limits of the server
+	TTL       int
+	Views     int
+	Deletable bool
+	// Wrong passwords before the share is destroyed, between 1 and 100
+	MaxAttempts int
+	// Comma separated IPs or CIDR ranges the share can only be downloaded from
+	AllowedIps string
+	// Url receiving the events of the share (downloaded, deleted, expired), if the server allows it
+	WebhookUrl string
+	// Signs the events sent to WebhookUrl, defaults to the secret of the server
+	WebhookSecret string
+}
+
+/**
+ * A file share as returned by the API.
+ */
+type File struct {
+	PasswordProvided bool   `json:"password_provided,omitempty"`
+	PasswordLink     string `json:"password_link,omitempty"`
+	TTL              int    `json:"ttl,omitempty"`
+	Views            int    `json:"views,omitempty"`
+	Deletable        bool   `json:"deletable,omitempty"`
+	// Key to give to the other methods, only set on creation
+	Key     string `json:"-"`
+	Link    string `json:"link,omitempty"`
+	LinkApi string `json:"link_api,omitempty"`
+}
+
+/**
+ * Upload the files with a streamed multipart form, they are zipped together by the server.
+ */
+func (c *Client) CreateFile(ctx context.Context, r FileRequest) (*File, error) {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeFileForm(form, r))
+	}()
+	defer pr.Close()
+
+	f := new(File)
+	err := c.doJSON(ctx, http.MethodPost, "/api/v1/file", form.FormDataContentType(), pr, http.StatusCreated, f)
+	if err != nil {
+		return nil, err
+	}
+	f.Key = keyOf(f.LinkApi, "/api/v1/file/")
+	return f, nil
+}
+
+func writeFileForm(form *multipart.Writer, r FileRequest) error {
+	if r.TTL != 0 {
+		form.WriteField("ttl", strconv.Itoa(r.TTL))
+	}
+	if r.Views != 0 {
+		form.WriteField("views", strconv.Itoa(r.Views))
+	}
+	form.WriteField("deletable", strconv.FormatBool(r.Deletable))
+	if r.Password != "" {
+		form.WriteField("password", r.Password)
+	}
+	if r.MaxAttempts != 0 {
+		form.WriteField("max_attempts", strconv.Itoa(r.MaxAttempts))
+	}
+	if r.AllowedIps != "" {
+		form.WriteField("allowed_ips", r.AllowedIps)
+	}
+	if r.WebhookUrl != "" {
+		form.WriteField("webhook_url", r.WebhookUrl)
+		form.WriteField("webhook_secret", r.WebhookSecret)
+	}
+
+	for _, file := range r.Files {
+		w, err := form.CreateFormFile("files", file.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, file.Content); err != nil {
+			return err
+		}
+	}
+	return form.Close()
+}
+
+/**
+ * Return the share information (ttl, views left, deletable, password needed) without consuming a view.
+ */
+func (c *Client) GetFile(ctx context.Context, key string) (*File, error) {
+	f := new(File)
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/file/"+url.PathEscape(key), "", nil, http.StatusOK, f)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+/**
+ * Download the zip archive of the files, a view is consumed. The caller must close the archive.
+ * ErrUnauthorized if the password is wrong.
+ */
+func (c *Client) DownloadFile(ctx context.Context, key string, password string) (io.ReadCloser, error) {
+	form := url.Values{"password": {password}}.Encode()
+	res, err := c.do(ctx, http.MethodPost, "/api/v1/file/"+url.PathEscape(key)+"/download",
+		"application/x-www-form-urlencoded", strings.NewReader(form), http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+/**
+ * Delete a deletable file share, ErrUnauthorized if it isn't.
+ */
+func (c *Client) DeleteFile(ctx context.Context, key string) error {
+	res, err := c.do(ctx, http.MethodDelete, "/api/v1/file/"+url.PathEscape(key), "", nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+/**
+ * A share link as given by Okuru: <server>/<key>, <server>/file/<key>, with #<key> for client encrypted secrets.
+ * API links (<server>/api/v1/...) are accepted too.
+ */
+type Link struct {
+	// Url of the server, to use with New
+	Server string
+	// Key of the share, with the #<key> fragment of client encrypted secrets
+	Key  string
+	File bool
+}
+
+func ParseLink(raw string) (*Link, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("okuru: invalid link %q", raw)
+	}
+
+	l := new(Link)
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	l.Key = path[i+1:]
+	if l.Key == "" {
+		return nil, fmt.Errorf("okuru: invalid link %q", raw)
+	}
+	if u.Fragment != "" {
+		l.Key += "#" + u.Fragment
+	}
+
+	path = path[:i]
+	if strings.HasSuffix(path, "/file") {
+		l.File = true
+		path = strings.TrimSuffix(path, "/file")
+	}
+	path = strings.TrimSuffix(path, "/api/v1")
+
+	u.Path, u.RawPath, u.RawQuery, u.Fragment = path, "", "", ""
+	l.Server = u.String()
+	return l, nil
+}
+
+/**
+ * Split a key in the storage key sent to the server and the client key of the fragment
+ */
+func splitKey(key string) (string, string) {
+	fragments := strings.SplitN(key, "#", 2)
+	if len(fragments) == 1 {
+		return fragments[0], ""
+	}
+	return fragments[0], fragments[1]
+}
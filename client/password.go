@@ -0,0 +1,156 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/**
+ * Options of a new password share, zero values use the server defaults.
+ */
+type PasswordRequest struct {
+	Password string `json:"password"`
+	// Seconds, within the limits of the server
+	TTL   int `json:"ttl,omitempty"`
+	Views int `json:"views,omitempty"`
+	// Let the recipient delete the share
+	Deletable bool `json:"deletable,omitempty"`
+	// Encrypt the password locally, the server only gets the ciphertext and the key is only part of the link
+	ClientEncrypted bool `json:"client_encrypted,omitempty"`
+	// Needed to reveal the password, it can't be used with ClientEncrypted
+	Passphrase string `json:"passphrase,omitempty"`
+	// Wrong passphrases before the share is destroyed, between 1 and 100
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// Comma separated IPs or CIDR ranges the share can only be revealed from
+	AllowedIps string `json:"allowed_ips,omitempty"`
+	// Url receiving the events of the share (revealed, deleted, expired), if the server allows it
+	WebhookUrl string `json:"webhook_url,omitempty"`
+	// Signs the events sent to WebhookUrl, defaults to the secret of the server
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+}
+
+/**
+ * A password share as returned by the API.
+ */
+type Password struct {
+	// Only returned by the reveal, the ciphertext for client encrypted shares, RevealPassword decrypts it
+	Password        string `json:"password,omitempty"`
+	TTL             int    `json:"ttl,omitempty"`
+	Views           int    `json:"views,omitempty"`
+	Deletable       bool   `json:"deletable,omitempty"`
+	ClientEncrypted bool   `json:"client_encrypted,omitempty"`
+	// A passphrase must be given to RevealPassword
+	PassphraseProtected bool `json:"passphrase_protected,omitempty"`
+	// Key to give to the other methods, only set on creation
+	Key     string `json:"-"`
+	Link    string `json:"link,omitempty"`
+	LinkApi string `json:"link_api,omitempty"`
+}
+
+func (c *Client) CreatePassword(ctx context.Context, r PasswordRequest) (*Password, error) {
+	var clientKey string
+	if r.ClientEncrypted {
+		var err error
+		r.Password, clientKey, err = encrypt(r.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	p := new(Password)
+	err = c.doJSON(ctx, http.MethodPost, "/api/v1", "application/json", bytes.NewReader(body), http.StatusCreated, p)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Key = keyOf(p.LinkApi, "/api/v1/")
+	if clientKey != "" {
+		p.Key += "#" + clientKey
+		p.Link += "#" + clientKey
+		p.LinkApi += "#" + clientKey
+	}
+	return p, nil
+}
+
+/**
+ * Return the share information (ttl, views left, deletable) without consuming a view, the secret is only returned
+ * by RevealPassword. The server doesn't return the ciphertext of client encrypted secrets here either.
+ */
+func (c *Client) GetPassword(ctx context.Context, key string) (*Password, error) {
+	storageKey, _ := splitKey(key)
+	p := new(Password)
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/"+url.PathEscape(storageKey), "", nil, http.StatusOK, p)
+	if err != nil {
+		return nil, err
+	}
+	p.Password = ""
+	return p, nil
+}
+
+/**
+ * Return the secret, a view is consumed. The passphrase is only needed if the share was created with one,
+ * ErrUnauthorized if it's wrong.
+ */
+func (c *Client) RevealPassword(ctx context.Context, key string, passphrase string) (string, error) {
+	storageKey, clientKey := splitKey(key)
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(c.BaseURL, "/")+"/api/v1/"+url.PathEscape(storageKey), nil)
+	if err != nil {
+		return "", err
+	}
+	if passphrase != "" {
+		req.Header.Set("Okuru-Passphrase", passphrase)
+	}
+	res, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", newError(res)
+	}
+
+	p := new(Password)
+	if err := json.NewDecoder(res.Body).Decode(p); err != nil {
+		return "", err
+	}
+	// The server only has the ciphertext of client encrypted secrets
+	if clientKey != "" || p.ClientEncrypted {
+		if !p.ClientEncrypted || clientKey == "" {
+			return "", ErrDecrypt
+		}
+		return decrypt(p.Password, clientKey)
+	}
+	return p.Password, nil
+}
+
+/**
+ * Delete a deletable password share, ErrUnauthorized if it isn't.
+ */
+func (c *Client) DeletePassword(ctx context.Context, key string) error {
+	storageKey, _ := splitKey(key)
+	res, err := c.do(ctx, http.MethodDelete, "/api/v1/"+url.PathEscape(storageKey), "", nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+/**
+ * The key is the end of the link, after the route prefix
+ */
+func keyOf(link, prefix string) string {
+	i := strings.LastIndex(link, prefix)
+	if i < 0 {
+		return ""
+	}
+	return link[i+len(prefix):]
+}
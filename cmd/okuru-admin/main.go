@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/eraffaelli/Okuru/store"
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/spf13/pflag"
+	bolt "go.etcd.io/bbolt"
+	"os"
+)
+
+const usage = `okuru-admin manages the API keys of an Okuru server, directly in its store.
+It reads the same configuration as the server, the file of OKURU_CONFIG and the environment (OKURU_STORE,
+REDIS_*...). The bolt store can only be opened by one process: stop the server first, okuru-admin gives up
+after 5 seconds while the server holds it. Use the redis store to manage the keys of a running server.
+
+Usage:
+  okuru-admin mint-key [--label label] [--quota n]   create an API key, it's printed only once
+  okuru-admin revoke-key key|id                      revoke an API key, given by the key or its id
+  okuru-admin show-key key|id                        print the label, quota and usage of the day of a key
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var command func(config *Config, args []string) error
+	switch os.Args[1] {
+	case "mint-key":
+		command = mintKey
+	case "revoke-key":
+		command = revokeKey
+	case "show-key":
+		command = showKey
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err := run(command, os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "okuru-admin:", err)
+		os.Exit(1)
+	}
+}
+
+/**
+ * Open the store of the server configuration and run the command on it, the store is closed before returning
+ */
+func run(command func(config *Config, args []string) error, args []string) (err error) {
+	config, err := LoadConfig(nil)
+	if err != nil {
+		return err
+	}
+	if config.Store == "memory" {
+		return fmt.Errorf("the memory store lives in the server process, use the redis or bolt store")
+	}
+	config.Services.Store, err = NewStore(config)
+	if errors.Is(err, bolt.ErrTimeout) {
+		return fmt.Errorf("the bolt store %s is opened by another process, stop the Okuru server first", config.BoltPath)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := config.Services.Store.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return command(config, args)
+}
+
+func mintKey(config *Config, args []string) error {
+	var label string
+	var quota int
+	flags := pflag.NewFlagSet("mint-key", pflag.ExitOnError)
+	flags.StringVar(&label, "label", "", "label to recognize the key, for example its owner")
+	flags.IntVar(&quota, "quota", 0, "number of shares the key can create per day, 0 for unlimited")
+	flags.Parse(args)
+	if quota < 0 {
+		return fmt.Errorf("quota can't be negative")
+	}
+
+	key, k, err := NewApiKey(config, label, quota)
+	if err != nil {
+		return err
+	}
+	fmt.Println("key:  ", key)
+	fmt.Println("id:   ", k.Id)
+	fmt.Println("label:", k.Label)
+	fmt.Println("quota:", quotaText(k.Quota))
+	return nil
+}
+
+func revokeKey(config *Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("revoke-key takes the key or its id")
+	}
+	k, err := RevokeApiKey(config, args[0])
+	if err == store.ErrNotFound {
+		return fmt.Errorf("no such key")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("revoked", k.Id, k.Label)
+	return nil
+}
+
+func showKey(config *Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("show-key takes the key or its id")
+	}
+	k, err := GetApiKey(config, ApiKeyIdOf(args[0]))
+	if err == store.ErrNotFound {
+		return fmt.Errorf("no such key")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("id:     ", k.Id)
+	fmt.Println("label:  ", k.Label)
+	fmt.Println("quota:  ", quotaText(k.Quota))
+	fmt.Println("created:", k.CreatedAt)
+	fmt.Println("used:   ", UsedToday(k), "today")
+	return nil
+}
+
+func quotaText(quota int) string {
+	if quota <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d shares per day", quota)
+}
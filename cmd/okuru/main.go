@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/eraffaelli/Okuru/client"
+	"github.com/spf13/pflag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const usage = `okuru shares secrets and files through an Okuru server.
+
+Usage:
+  okuru send [file]               share a secret read from file or stdin, prints the link
+  okuru send-file file...         share files zipped together, prints the link
+  okuru get link                  print the secret of a link, or download the file of a file link
+  okuru delete link               delete a deletable secret or file before it expires
+
+The server is given with --server or OKURU_URL, links already contain it.
+Run "okuru <command> --help" for the options of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "send":
+		err = send(os.Args[2:])
+	case "send-file":
+		err = sendFile(os.Args[2:])
+	case "get":
+		err = get(os.Args[2:])
+	case "delete":
+		err = remove(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "okuru:", describe(err))
+		os.Exit(1)
+	}
+}
+
+/**
+ * Explain the errors of the API in the words of the command line
+ */
+func describe(err error) string {
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		return "not found, it expired, was already viewed or deleted"
+	case errors.Is(err, client.ErrUnauthorized) && strings.Contains(err.Error(), "API key"):
+		return strings.TrimPrefix(err.Error(), "okuru: ") + ", use --api-key or OKURU_API_KEY"
+	case errors.Is(err, client.ErrUnauthorized):
+		return "wrong password or passphrase, or the share isn't deletable"
+	case errors.Is(err, client.ErrForbidden):
+		return "this share can't be opened from your network"
+	}
+	return strings.TrimPrefix(err.Error(), "okuru: ")
+}
+
+/**
+ * Options shared by the commands creating a share
+ */
+type shareFlags struct {
+	server        string
+	apiKey        string
+	ttl           int
+	views         int
+	deletable     bool
+	maxAttempts   int
+	allowedIps    string
+	webhookUrl    string
+	webhookSecret string
+}
+
+func newShareFlags(name string, options *shareFlags) *pflag.FlagSet {
+	flags := pflag.NewFlagSet(name, pflag.ExitOnError)
+	flags.StringVar(&options.server, "server", os.Getenv("OKURU_URL"), "url of the Okuru server, defaults to OKURU_URL")
+	flags.StringVar(&options.apiKey, "api-key", os.Getenv("OKURU_API_KEY"), "API key of the server if it requires one, defaults to OKURU_API_KEY")
+	flags.IntVar(&options.ttl, "ttl", 0, "seconds before the share expires, within the limits of the server, defaults to the server setting")
+	flags.IntVar(&options.views, "views", 0, "number of views before the share is deleted, within the limits of the server, defaults to the server setting")
+	flags.BoolVar(&options.deletable, "deletable", false, "let the recipient delete the share")
+	flags.IntVar(&options.maxAttempts, "max-attempts", 0, "wrong passwords or passphrases before the share is destroyed, between 1 and 100, defaults to the server setting")
+	flags.StringVar(&options.allowedIps, "allowed-ips", "", "comma separated IPs or CIDR ranges the share can only be opened from")
+	flags.StringVar(&options.webhookUrl, "webhook-url", "", "url notified when the share is opened, deleted or expires, if the server allows it")
+	flags.StringVar(&options.webhookSecret, "webhook-secret", os.Getenv("OKURU_WEBHOOK_SECRET"), "secret signing the webhook events, defaults to OKURU_WEBHOOK_SECRET")
+	return flags
+}
+
+func (o *shareFlags) validate() error {
+	if o.server == "" {
+		return fmt.Errorf("no server, use --server or OKURU_URL")
+	}
+	if o.ttl < 0 {
+		return fmt.Errorf("ttl can't be negative")
+	}
+	if o.views < 0 {
+		return fmt.Errorf("views can't be negative")
+	}
+	if o.maxAttempts < 0 || o.maxAttempts > 100 {
+		return fmt.Errorf("max attempts out of range (min 1, max 100)")
+	}
+	return nil
+}
+
+func (o *shareFlags) client() *client.Client {
+	c := client.New(o.server)
+	c.ApiKey = o.apiKey
+	return c
+}
+
+func send(args []string) error {
+	var options shareFlags
+	var serverEncryption bool
+	var passphrase string
+	flags := newShareFlags("send", &options)
+	flags.BoolVar(&serverEncryption, "server-encryption", false, "send the secret in clear to the server that encrypts it, by default it's encrypted locally and the server never sees it")
+	flags.StringVar(&passphrase, "passphrase", "", "passphrase needed to reveal the secret, implies --server-encryption")
+	flags.Parse(args)
+	if err := options.validate(); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return fmt.Errorf("send takes at most one file")
+	}
+
+	var secret []byte
+	var err error
+	if flags.NArg() == 1 {
+		secret, err = ioutil.ReadFile(flags.Arg(0))
+	} else {
+		secret, err = ioutil.ReadAll(os.Stdin)
+		// echo and most shells add a newline that isn't part of the secret
+		secret = []byte(strings.TrimSuffix(strings.TrimSuffix(string(secret), "\n"), "\r"))
+	}
+	if err != nil {
+		return err
+	}
+	if len(secret) == 0 {
+		return fmt.Errorf("empty secret")
+	}
+
+	p, err := options.client().CreatePassword(context.Background(), client.PasswordRequest{
+		Password:        string(secret),
+		TTL:             options.ttl,
+		Views:           options.views,
+		Deletable:       options.deletable,
+		ClientEncrypted: !serverEncryption && passphrase == "",
+		Passphrase:      passphrase,
+		MaxAttempts:     options.maxAttempts,
+		AllowedIps:      options.allowedIps,
+		WebhookUrl:      options.webhookUrl,
+		WebhookSecret:   options.webhookSecret,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(p.Link)
+	return nil
+}
+
+func sendFile(args []string) error {
+	var options shareFlags
+	var password string
+	flags := newShareFlags("send-file", &options)
+	flags.StringVar(&password, "password", "", "password needed to download the files, a second link to retrieve it is printed")
+	flags.Parse(args)
+	if err := options.validate(); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no file to send")
+	}
+
+	request := client.FileRequest{
+		Password:      password,
+		TTL:           options.ttl,
+		Views:         options.views,
+		Deletable:     options.deletable,
+		MaxAttempts:   options.maxAttempts,
+		AllowedIps:    options.allowedIps,
+		WebhookUrl:    options.webhookUrl,
+		WebhookSecret: options.webhookSecret,
+	}
+	for _, name := range flags.Args() {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		request.Files = append(request.Files, client.FileUpload{Name: filepath.Base(name), Content: file})
+	}
+
+	f, err := options.client().CreateFile(context.Background(), request)
+	if err != nil {
+		return err
+	}
+	fmt.Println(f.Link)
+	if f.PasswordLink != "" {
+		fmt.Println(f.PasswordLink)
+	}
+	return nil
+}
+
+func get(args []string) error {
+	var output, password, passphrase string
+	flags := pflag.NewFlagSet("get", pflag.ExitOnError)
+	flags.StringVar(&passphrase, "passphrase", "", "passphrase of a secret protected by one")
+	flags.StringVarP(&output, "output", "o", "", "file where a downloaded archive is written, defaults to <name>.zip, - for stdout")
+	flags.StringVar(&password, "password", "", "password of a file share protected by one")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("get takes one link")
+	}
+
+	l, err := client.ParseLink(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	c := client.New(l.Server)
+
+	if !l.File {
+		secret, err := c.RevealPassword(context.Background(), l.Key, passphrase)
+		if err != nil {
+			return err
+		}
+		fmt.Println(secret)
+		return nil
+	}
+
+	archive, err := c.DownloadFile(context.Background(), l.Key, password)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	if output == "-" {
+		_, err = io.Copy(os.Stdout, archive)
+		return err
+	}
+	if output == "" {
+		// The uuid part of the key, without the separator and the decryption key
+		output = l.Key
+		if len(output) > 36 {
+			output = output[:36]
+		}
+		output += ".zip"
+	}
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, archive)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(output)
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "saved to", output)
+	return nil
+}
+
+func remove(args []string) error {
+	flags := pflag.NewFlagSet("delete", pflag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("delete takes one link")
+	}
+
+	l, err := client.ParseLink(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	c := client.New(l.Server)
+	if l.File {
+		return c.DeleteFile(context.Background(), l.Key)
+	}
+	return c.DeletePassword(context.Background(), l.Key)
+}
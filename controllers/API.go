@@ -5,6 +5,7 @@ import (
 	. "github.com/eraffaelli/Okuru/utils"
 	"github.com/labstack/echo"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -12,18 +13,44 @@ import (
  * Print help for API usage in a readable way for bash/curl call
  */
 func HelpPassword(context echo.Context) error {
+	config := GetConfig(context)
 	help := `Generate a password share link with the following parameters:
 password : (required), the password
-ttl: (optional) number seconds, min: 300, max: 604800, default: 3600 (one hour)
-views: (optional) number between 1 and 100
+` + config.PasswordLimits().Help() + `
 deletable: (optional) boolean (false, true), default: false
+passphrase: (optional) a passphrase that must be given to reveal the password, send it to the recipient by another way
+max_attempts: (optional) number of wrong passphrases before the password is destroyed, between 1 and 100, default: ` + strconv.Itoa(config.MaxFailedAttempts) + `
+allowed_ips: (optional) comma separated IPs or CIDR ranges (e.g. "10.0.0.0/8,2001:db8::/32"), the password can only be read from them
+` + webhookHelp(config) + `
+client_encrypted: (optional) boolean, the password is the base64 of the 12 bytes nonce followed by the AES-256-GCM ciphertext, encrypted with a key the server never sees.
+The returned link must be completed with #key (base64url of the key without padding) and revealing it returns the ciphertext.
 For example with the following command:
-curl -X POST -H "Content-Type:application/json" -d '{"password":"password-here","ttl":seconds, "views":views, "deletable": true}' ` + GetBaseUrl(context) + "/api/v1"
+curl -X POST -H "Content-Type:application/json" -d '{"password":"password-here","ttl":seconds, "views":views, "deletable": true}' ` + GetBaseUrl(context) + `/api/v1
+Read the share information (ttl, views left, client_encrypted...) without consuming a view:
+curl ` + GetBaseUrl(context) + `/api/v1/<password_key>
+Reveal the password, or the ciphertext of a client encrypted password (consume a view), with the passphrase if one was set:
+curl -X POST -H "Okuru-Passphrase: passphrase-here" ` + GetBaseUrl(context) + "/api/v1/<password_key>"
+	if config.ApiKeys {
+		help += "\nThis server needs an API key to create a share, send it with -H \"Okuru-Api-Key: key-here\", reading a share doesn't need it"
+	}
 	return context.String(http.StatusOK, help)
 }
 
 /**
- * From a given token, return the initial password, we decrypt the password fetched from Redis.
+ * Help of the webhook parameters, shared with the file API
+ */
+func webhookHelp(config *Config) string {
+	if !config.ShareWebhooks {
+		return "webhook_url: not enabled on this server"
+	}
+	return `webhook_url: (optional) url receiving a JSON event (revealed, downloaded, deleted or expired) signed in Okuru-Signature
+webhook_secret: (optional) secret signing the events of webhook_url, defaults to the secret of the server`
+}
+
+/**
+ * From a given token, return the share information (ttl, views left) without consuming a view.
+ * The password is only returned by RevealPasswordApi, the ciphertext of client encrypted passwords too: the link holds
+ * its key, returning it here would let anyone with the link read the secret without using up the views.
  */
 func ReadPassword(context echo.Context) error {
 	p := new(Password)
@@ -32,14 +59,48 @@ func ReadPassword(context echo.Context) error {
 		return context.NoContent(http.StatusNotFound)
 	}
 
-	err := GetPassword(p)
+	p.ClientIp = ClientIP(context)
+	err := GetPassword(GetConfig(context), p)
+	if err != nil {
+		if err.Code == http.StatusForbidden {
+			return context.JSON(err.Code, err.Message)
+		}
+		return context.NoContent(http.StatusNotFound)
+	}
+
+	// Empty var so json response don't have them
+	p.Token = []byte("")
+	p.PasswordKey = ""
+	return context.JSON(http.StatusOK, p)
+}
+
+/**
+ * From a given token and its passphrase if it has one, return the password and consume a view.
+ * The passphrase is read from the Okuru-Passphrase header or the passphrase form value.
+ */
+func RevealPasswordApi(context echo.Context) error {
+	p := new(Password)
+	p.PasswordKey = context.Param("password_key")
+	if p.PasswordKey == "" {
+		return context.NoContent(http.StatusNotFound)
+	}
+
+	if p.Passphrase = context.Request().Header.Get("Okuru-Passphrase"); p.Passphrase == "" {
+		p.Passphrase = context.FormValue("passphrase")
+	}
+	p.ClientIp = ClientIP(context)
+	err := RetrievePassword(GetConfig(context), p)
 	if err != nil {
+		if err.Code == http.StatusUnauthorized || err.Code == http.StatusForbidden {
+			return context.JSON(err.Code, err.Message)
+		}
 		return context.NoContent(http.StatusNotFound)
 	}
 
 	// Empty var so json response don't have them
 	p.Token = []byte("")
 	p.PasswordKey = ""
+	p.Passphrase = ""
 	return context.JSON(http.StatusOK, p)
 }
 
@@ -55,24 +116,42 @@ func CreatePassword(context echo.Context) (err error) {
 		return context.NoContent(http.StatusBadRequest)
 	}
 
-	if p.Views == 0 {
-		p.Views = 1
+	config := GetConfig(context)
+	var err2 *echo.HTTPError
+	if p.Views, err2 = config.PasswordLimits().CheckViews(p.Views); err2 != nil {
+		return context.JSON(err2.Code, err2.Message)
+	}
+	if p.TTL, err2 = config.PasswordLimits().CheckTtl(p.TTL); err2 != nil {
+		return context.JSON(err2.Code, err2.Message)
+	}
+	if p.ClientEncrypted && p.Passphrase != "" {
+		return context.JSON(http.StatusBadRequest, "A passphrase can't be used with a client encrypted password")
 	}
-	if p.Views > 100 {
-		return context.JSON(http.StatusBadRequest, "Views too high (max 100)")
+	if p.MaxAttempts < 0 || p.MaxAttempts > 100 {
+		return context.JSON(http.StatusBadRequest, "Max attempts out of range (min 1, max 100)")
 	}
-	if p.TTL == 0 {
-		p.TTL = 3600
+	if p.AllowedIps, err2 = ParseAllowedIps(p.AllowedIps); err2 != nil {
+		return context.JSON(err2.Code, err2.Message)
 	}
-	if p.TTL > 604800 {
-		return context.JSON(http.StatusBadRequest, "TTL too high (max 604800 seconds)")
+	if p.WebhookUrl, err2 = ParseWebhookUrl(config, p.WebhookUrl); err2 != nil {
+		return context.JSON(err2.Code, err2.Message)
 	}
 
-	token, err2 := SetPassword(p.Password, p.TTL, p.Views, p.Deletable)
+	var token string
+	if p.ClientEncrypted {
+		token, err2 = SetClientPassword(config, p.Password, p.TTL, p.Views, p.Deletable, p.AllowedIps)
+	} else {
+		token, err2 = SetPassword(config, p.Password, p.TTL, p.Views, p.Deletable, p.Passphrase, p.MaxAttempts, p.AllowedIps)
+	}
 	if err2 != nil {
+		if err2.Code == http.StatusBadRequest {
+			return context.JSON(err2.Code, err2.Message)
+		}
 		return context.JSON(http.StatusInternalServerError, "A problem occured during the processus. Please contact the administrator of the website")
 	}
 
+	SetWebhook(config, strings.Split(token, config.TokenSeparator)[0], p.WebhookUrl, p.WebhookSecret, p.TTL)
+
 	baseUrl := GetBaseUrl(context) + "/"
 	p.PasswordKey = token
 	p.Link = baseUrl + token
@@ -82,6 +161,9 @@ func CreatePassword(context echo.Context) (err error) {
 	p.Token = []byte("")
 	p.Password = ""
 	p.PasswordKey = ""
+	p.PassphraseProtected = p.Passphrase != ""
+	p.Passphrase = ""
+	p.WebhookSecret = ""
 
 	return context.JSON(http.StatusCreated, p)
 }
@@ -96,7 +178,7 @@ func DeletePassword(context echo.Context) error {
 		return context.NoContent(http.StatusNotFound)
 	}
 
-	err := RemovePassword(p)
+	err := RemovePassword(GetConfig(context), p)
 	var status int
 	if err != nil {
 		status = err.Code
@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	. "github.com/eraffaelli/Okuru/models"
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Print help for file API usage in a readable way for bash/curl call
+ */
+func HelpFile(context echo.Context) error {
+	config := GetConfig(context)
+	baseUrl := GetBaseUrl(context) + "/api/v1/file"
+	help := `Generate a file share link with the following multipart form parameters:
+files : (required), one or more files, they will be zipped together (total upload max size ` + GetMaxFileSizeText(config) + `)
+password : (optional) password needed to download the file, a link to retrieve it is returned in password_link
+` + config.FileLimits().Help() + `
+deletable: (optional) boolean (false, true), default: false
+max_attempts: (optional) number of wrong passwords before the file is destroyed, between 1 and 100, default: ` + strconv.Itoa(config.MaxFailedAttempts) + `
+allowed_ips: (optional) comma separated IPs or CIDR ranges (e.g. "10.0.0.0/8,2001:db8::/32"), the file can only be downloaded from them
+` + webhookHelp(config) + `
+For example with the following command:
+curl -X POST -F "files=@/path/to/file" -F "ttl=3600" -F "views=1" -F "deletable=true" ` + baseUrl + `
+Get the file share information (ttl, views left) without consuming a view:
+curl ` + baseUrl + `/<file_key>
+Download the file (consume a view):
+curl -X POST -F "password=password-here" -o file.zip ` + baseUrl + `/<file_key>/download
+Delete the file if it's deletable:
+curl -X DELETE ` + baseUrl + `/<file_key>
+Big files can be sent with a resumable upload using any tus client on ` + GetBaseUrl(context) + `/api/v1/upload`
+	if config.ApiKeys {
+		help += "\nThis server needs an API key to create a share, send it with -H \"Okuru-Api-Key: key-here\", reading a share doesn't need it"
+	}
+	return context.String(http.StatusOK, help)
+}
+
+/**
+ * From the uploaded files, return links
+ */
+func CreateFile(context echo.Context) error {
+	var err error
+	config := GetConfig(context)
+	f := new(File)
+
+	reader, err := context.Request().MultipartReader()
+	if err != nil {
+		return context.JSON(http.StatusBadRequest, "A multipart form is expected")
+	}
+
+	token, err := NewFileToken(config)
+	if err != nil {
+		return context.JSON(http.StatusInternalServerError, "A problem occured during the processus. Please contact the administrator of the website")
+	}
+
+	// The files are streamed into the archive, the other fields can be sent before or after them
+	values, err2 := StreamFiles(config, reader, "files", token)
+	if err2 != nil {
+		return context.JSON(err2.Code, err2.Message)
+	}
+
+	f.FileKey = token
+	// Nothing is stored yet but the archive
+	fail := func(code int, message string) error {
+		CleanFile(config, strings.Split(token, config.TokenSeparator)[0])
+		return context.JSON(code, message)
+	}
+
+	f.Password = values.Get("password")
+
+	if f.TTL, err2 = config.FileLimits().ParseTtl(values.Get("ttl")); err2 != nil {
+		return fail(err2.Code, err2.Message.(string))
+	}
+	if f.Views, err2 = config.FileLimits().ParseViews(values.Get("views")); err2 != nil {
+		return fail(err2.Code, err2.Message.(string))
+	}
+
+	f.Deletable, _ = strconv.ParseBool(values.Get("deletable"))
+
+	if f.MaxAttempts, err2 = ParseMaxAttempts(values.Get("max_attempts")); err2 != nil {
+		return fail(err2.Code, err2.Message.(string))
+	}
+	if f.AllowedIps, err2 = ParseAllowedIps(values.Get("allowed_ips")); err2 != nil {
+		return fail(err2.Code, err2.Message.(string))
+	}
+	if f.WebhookUrl, err2 = ParseWebhookUrl(config, values.Get("webhook_url")); err2 != nil {
+		return fail(err2.Code, err2.Message.(string))
+	}
+	f.WebhookSecret = values.Get("webhook_secret")
+
+	var provided = false
+	var passwordToken string
+	if len(f.Password) == 0 {
+		f.Password = RandomSequence(50)
+	} else {
+		provided = true
+
+		passwordToken, err2 = SetPassword(config, f.Password, f.TTL, f.Views, false, "", 0, f.AllowedIps) // Same as the web form, the password is deleted with the file
+		if err2 != nil {
+			return fail(http.StatusInternalServerError, "A problem occured during the processus. Please contact the administrator of the website")
+		}
+		f.PasswordProvidedKey = strings.Split(passwordToken, config.TokenSeparator)[0]
+	}
+
+	if err2 := SetFile(config, token, f.Password, f.TTL, f.Views, f.Deletable, provided, f.PasswordProvidedKey, f.MaxAttempts, f.AllowedIps); err2 != nil {
+		// Remove the password share created above with the file
+		DiscardFile(config, f)
+		return fail(http.StatusInternalServerError, "A problem occured during the processus. Please contact the administrator of the website")
+	}
+	SetWebhook(config, "file_"+strings.Split(token, config.TokenSeparator)[0], f.WebhookUrl, f.WebhookSecret, f.TTL)
+
+	baseUrl := GetBaseUrl(context) + "/"
+	f.Link = baseUrl + "file/" + token
+	f.LinkApi = baseUrl + "api/v1/file/" + token
+	if provided {
+		f.PasswordLink = baseUrl + passwordToken
+	}
+
+	// Empty var so json response don't have them
+	f.Password = ""
+	f.FileKey = ""
+	f.PasswordProvidedKey = ""
+	f.WebhookSecret = ""
+
+	return context.JSON(http.StatusCreated, f)
+}
+
+/**
+ * From a given token, return the file share information without consuming a view.
+ */
+func ReadFileApi(context echo.Context) error {
+	f := new(File)
+	f.FileKey = context.Param("file_key")
+	if f.FileKey == "" {
+		return context.NoContent(http.StatusNotFound)
+	}
+
+	f.ClientIp = ClientIP(context)
+	err := GetFileInfo(GetConfig(context), f)
+	if err != nil {
+		if err.Code == http.StatusForbidden {
+			return context.JSON(err.Code, err.Message)
+		}
+		return context.NoContent(err.Code)
+	}
+
+	// Empty var so json response don't have them
+	f.FileKey = ""
+	f.PasswordProvidedKey = ""
+	return context.JSON(http.StatusOK, f)
+}
+
+/**
+ * From a given token, check the password if one was provided at creation, consume a view and send the archive.
+ */
+func DownloadFileApi(context echo.Context) error {
+	f := new(File)
+	f.FileKey = context.Param("file_key")
+	if f.FileKey == "" {
+		return context.NoContent(http.StatusNotFound)
+	}
+
+	config := GetConfig(context)
+	f.ClientIp = ClientIP(context)
+	err := RetrieveFilePassword(config, f)
+	if err != nil {
+		if err.Code == http.StatusForbidden {
+			return context.JSON(err.Code, err.Message)
+		}
+		return context.NoContent(http.StatusNotFound)
+	}
+
+	if err := CheckFilePassword(config, f, context.FormValue("password")); err != nil {
+		return context.JSON(err.Code, err.Message)
+	}
+
+	last, err := ConsumeFileView(config, f)
+	if err != nil {
+		return context.NoContent(err.Code)
+	}
+
+	if last {
+		defer CleanFile(config, strings.Split(f.FileKey, config.TokenSeparator)[0])
+	}
+	return sendFile(context, f.FileKey)
+}
+
+/**
+ * From a given token, remove the file share.
+ */
+func DeleteFileApi(context echo.Context) error {
+	f := new(File)
+	f.FileKey = context.Param("file_key")
+	if f.FileKey == "" || strings.Contains(f.FileKey, "*") {
+		return context.NoContent(http.StatusNotFound)
+	}
+
+	err := RemoveFile(GetConfig(context), f)
+	if err != nil {
+		return context.NoContent(err.Code)
+	}
+	return context.NoContent(http.StatusOK)
+}
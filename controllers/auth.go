@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+/**
+ * Redirect to the login page of the OpenID Connect provider
+ */
+func Login(context echo.Context) error {
+	config := GetConfig(context)
+	if config.Services.OIDC == nil {
+		return context.NoContent(http.StatusNotFound)
+	}
+
+	redirect, err := config.Services.OIDC.Login(context, LocalPath(context.QueryParam("next")))
+	if err != nil {
+		log.Errorf("Login() err : %+v", err)
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	return context.Redirect(http.StatusFound, redirect)
+}
+
+/**
+ * The provider redirects here once the user is logged in, start the session and go back to the page asked first
+ */
+func LoginCallback(context echo.Context) error {
+	config := GetConfig(context)
+	if config.Services.OIDC == nil {
+		return context.NoContent(http.StatusNotFound)
+	}
+
+	u, next, err := config.Services.OIDC.Callback(context)
+	if err != nil {
+		log.Warn("LoginCallback() login failed : ", err)
+		return context.String(http.StatusUnauthorized, "The login failed, please try again")
+	}
+	if err := SetSession(context, u); err != nil {
+		log.Errorf("LoginCallback() err session : %+v", err)
+		return context.NoContent(http.StatusInternalServerError)
+	}
+	log.WithField("sub", u.Subject).WithField("email", u.Email).Info("User logged in")
+	return context.Redirect(http.StatusFound, config.BasePath()+LocalPath(next))
+}
+
+func Logout(context echo.Context) error {
+	ClearSession(context)
+	return context.Redirect(http.StatusFound, GetConfig(context).BasePath()+"/")
+}
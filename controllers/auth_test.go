@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+ * OpenID Connect provider serving the discovery, the keys and the token endpoint. The id_token it gives has the
+ * nonce set with expect, and the code is only exchanged with the verifier of the challenge set with it.
+ */
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	nonce     string
+	challenge string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockIssuer) expect(nonce, challenge string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nonce = nonce
+	m.challenge = challenge
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if r.PostFormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != m.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := m.sign(map[string]interface{}{
+		"iss":   m.URL,
+		"sub":   "user-1",
+		"aud":   "okuru",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": m.nonce,
+		"email": "user@example.com",
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (m *mockIssuer) sign(claims map[string]interface{}) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+/**
+ * The auth routes of a server using the mock issuer, /whoami answers with the identity of the session
+ */
+func newTestAuth(t *testing.T) (*echo.Echo, *mockIssuer) {
+	issuer := newMockIssuer(t)
+	config := DefaultConfig()
+	config.NoSsl = true
+	config.OidcIssuer = issuer.URL
+	config.OidcClientId = "okuru"
+
+	var err error
+	config.Services.OIDC, err = NewOIDC(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Pre(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(context echo.Context) error {
+			SetConfig(context, config)
+			return next(context)
+		}
+	})
+	e.GET("/auth/login", Login)
+	e.GET("/auth/callback", LoginCallback)
+	e.GET("/whoami", func(context echo.Context) error {
+		u := GetSession(context)
+		if u == nil {
+			return context.NoContent(http.StatusUnauthorized)
+		}
+		return context.String(http.StatusOK, Identity(u))
+	})
+	return e, issuer
+}
+
+func serve(e *echo.Echo, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func cookieOf(recorder *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == name && cookie.Value != "" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+/**
+ * Start a login, return the login cookie and the query of the redirection to the provider
+ */
+func startLogin(t *testing.T, e *echo.Echo, next string) (*http.Cookie, url.Values) {
+	recorder := serve(e, "/auth/login?next="+url.QueryEscape(next))
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("login code = %d, want 302", recorder.Code)
+	}
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := location.Query()
+	if location.Path != "/authorize" || query.Get("state") == "" || query.Get("nonce") == "" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("login redirects to %s", location)
+	}
+	cookie := cookieOf(recorder, "okuru_login")
+	if cookie == nil {
+		t.Fatal("login didn't set the login cookie")
+	}
+	return cookie, query
+}
+
+func TestLogin(t *testing.T) {
+	e, issuer := newTestAuth(t)
+
+	cookie, query := startLogin(t, e, "/file")
+	issuer.expect(query.Get("nonce"), query.Get("code_challenge"))
+	recorder := serve(e, "/auth/callback?code=code&state="+url.QueryEscape(query.Get("state")), cookie)
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/file" {
+		t.Fatalf("callback = %d to %q, want 302 to /file: %s", recorder.Code, recorder.Header().Get("Location"), recorder.Body)
+	}
+	session := cookieOf(recorder, SessionCookie)
+	if session == nil || !session.HttpOnly {
+		t.Fatalf("callback session cookie = %+v", session)
+	}
+
+	if recorder := serve(e, "/whoami", session); recorder.Body.String() != "user@example.com" {
+		t.Errorf("session of %q, want user@example.com", recorder.Body)
+	}
+}
+
+func TestLoginBadState(t *testing.T) {
+	e, issuer := newTestAuth(t)
+
+	cookie, query := startLogin(t, e, "/file")
+	issuer.expect(query.Get("nonce"), query.Get("code_challenge"))
+	recorder := serve(e, "/auth/callback?code=code&state=forged", cookie)
+	if recorder.Code != http.StatusUnauthorized || cookieOf(recorder, SessionCookie) != nil {
+		t.Errorf("callback with another state = %d, want 401 without session", recorder.Code)
+	}
+
+	// Without the login cookie the state can't be checked
+	recorder = serve(e, "/auth/callback?code=code&state="+url.QueryEscape(query.Get("state")))
+	if recorder.Code != http.StatusUnauthorized || cookieOf(recorder, SessionCookie) != nil {
+		t.Errorf("callback without login cookie = %d, want 401 without session", recorder.Code)
+	}
+}
+
+func TestLoginNonceMismatch(t *testing.T) {
+	e, issuer := newTestAuth(t)
+
+	cookie, query := startLogin(t, e, "/file")
+	issuer.expect("replayed", query.Get("code_challenge"))
+	recorder := serve(e, "/auth/callback?code=code&state="+url.QueryEscape(query.Get("state")), cookie)
+	if recorder.Code != http.StatusUnauthorized || cookieOf(recorder, SessionCookie) != nil {
+		t.Errorf("callback with an id_token of another nonce = %d, want 401 without session", recorder.Code)
+	}
+}
+
+func TestLoginNextStaysLocal(t *testing.T) {
+	e, issuer := newTestAuth(t)
+
+	for _, next := range []string{"//evil.example", "/\\evil.example", "https://evil.example"} {
+		cookie, query := startLogin(t, e, next)
+		issuer.expect(query.Get("nonce"), query.Get("code_challenge"))
+		recorder := serve(e, "/auth/callback?code=code&state="+url.QueryEscape(query.Get("state")), cookie)
+		if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/" {
+			t.Errorf("login with next %q = %d to %q, want 302 to /", next, recorder.Code, recorder.Header().Get("Location"))
+		}
+	}
+}
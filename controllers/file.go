@@ -1,30 +1,27 @@
 package controllers
 
 import (
-	"compress/flate"
 	"fmt"
 	. "github.com/eraffaelli/Okuru/models"
 	. "github.com/eraffaelli/Okuru/utils"
 	"github.com/labstack/echo"
-	"github.com/mholt/archiver"
 	log "github.com/sirupsen/logrus"
-	"io"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
 )
 
 func IndexFile(context echo.Context) error {
-	delete(DataContext, "errors")
-	DataContext["maxFileSize"] = MaxFileSize
-	DataContext["maxFileSizeText"] = GetMaxFileSizeText()
+	config := GetConfig(context)
+	data := config.DataContext()
+	data["maxFileSize"] = config.MaxFileSizeBytes()
+	data["maxFileSizeText"] = GetMaxFileSizeText(config)
 
-	return context.Render(http.StatusOK, "index_file.html", DataContext)
+	return context.Render(http.StatusOK, "index_file.html", data)
 }
 
 func ReadFile(context echo.Context) error {
-	delete(DataContext, "errors")
+	config := GetConfig(context)
+	data := config.DataContext()
 	f := new(File)
 	f.FileKey = context.Param("file_key")
 
@@ -38,9 +35,14 @@ func ReadFile(context echo.Context) error {
 		return nil
 	}
 
-	err := GetFile(f)
+	f.ClientIp = ClientIP(context)
+	// Opening the page doesn't count a view, only the download does
+	err := GetFileInfo(config, f)
 	if err != nil {
-		return context.Render(http.StatusNotFound, "404.html", DataContext)
+		if err.Code == http.StatusForbidden {
+			return context.String(err.Code, err.Message.(string))
+		}
+		return context.Render(http.StatusNotFound, "404.html", data)
 	}
 
 	var (
@@ -56,24 +58,23 @@ func ReadFile(context echo.Context) error {
 		println("deletableURL : ", deletableURL)
 	}
 
-	DataContext["f"] = f
-	DataContext["ttl"] = GetTTLText(f.TTL)
-	DataContext["deletableText"] = deletableText
-	DataContext["deletableURL"] = deletableURL
+	data["f"] = f
+	data["ttl"] = GetTTLText(f.TTL)
+	data["deletableText"] = deletableText
+	data["deletableURL"] = deletableURL
 
 	if f.PasswordProvided == true {
-		DataContext["passwordNeeded"] = true
+		data["passwordNeeded"] = true
 	} else {
-		DataContext["passwordNeeded"] = false
+		data["passwordNeeded"] = false
 	}
 
 	//Todo: change text "is up for" is ttl < 3600
 
-	return context.Render(http.StatusOK, "file.html", DataContext)
+	return context.Render(http.StatusOK, "file.html", data)
 }
 
 func DownloadFile(context echo.Context) error {
-	var passwordOk = true
 	f := new(File)
 	f.FileKey = context.Param("file_key")
 	if f.FileKey == "" {
@@ -86,67 +87,116 @@ func DownloadFile(context echo.Context) error {
 		return nil
 	}
 
-	err := RetrieveFilePassword(f)
+	config := GetConfig(context)
+	f.ClientIp = ClientIP(context)
+	err := RetrieveFilePassword(config, f)
 	if err != nil {
-		log.Error("%+v\n", err)
+		log.Errorf("%+v", err)
+		if err.Code == http.StatusForbidden {
+			return context.String(err.Code, err.Message.(string))
+		}
 		return context.NoContent(http.StatusNotFound)
 	}
 
-	if f.PasswordProvided == true {
-		password := context.FormValue("password")
-		if password != f.Password {
-			passwordOk = false
-		}
+	if err := CheckFilePassword(config, f, context.FormValue("password")); err != nil {
+		return context.String(err.Code, err.Message.(string))
 	}
 
-	if passwordOk == false {
-		// Todo: this will cause a views counted if the person comme again on the link instead of back button
-		return context.String(http.StatusUnauthorized, "You don't have the permission to open that file")
+	last, err := ConsumeFileView(config, f)
+	if err != nil {
+		return context.NoContent(err.Code)
 	}
 
-	fileName := strings.Split(f.FileKey, TOKEN_SEPARATOR)[0]
-	filePathName := FILEFOLDER + "/" + fileName + ".zip"
-	return context.Attachment(filePathName, fileName+".zip")
+	if last {
+		defer CleanFile(config, strings.Split(f.FileKey, config.TokenSeparator)[0])
+	}
+	return sendFile(context, f.FileKey)
 }
 
+/**
+ * Stream the decrypted archive of a file share as an attachment
+ */
+func sendFile(context echo.Context, fileKey string) error {
+	config := GetConfig(context)
+	reader, err := OpenFile(config, fileKey)
+	if err != nil {
+		log.Errorf("Error while opening file : %+v", err)
+		return context.NoContent(http.StatusNotFound)
+	}
+	defer reader.Close()
+
+	fileName := strings.Split(fileKey, config.TokenSeparator)[0] + ".zip"
+	context.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fileName))
+	return context.Stream(http.StatusOK, "application/zip", reader)
+}
+
+/**
+ * Create a file share from the web form. The errors are rendered with the form and an error status, so the API key
+ * quota isn't used by a refused share.
+ */
 func AddFile(context echo.Context) error {
-	delete(DataContext, "errors")
+	config := GetConfig(context)
+	data := config.DataContext()
 	var err error
 	f := new(File)
-	f.Password = context.FormValue("password")
 
-	f.TTL, err = strconv.Atoi(context.FormValue("ttl"))
+	// The files are streamed into the archive first, the other fields can only be read once they are done
+	reader, err := context.Request().MultipartReader()
 	if err != nil {
-		log.Error("%+v\n", err)
-		DataContext["errors"] = err.Error()
-		return context.Render(http.StatusOK, "index_file.html", DataContext)
+		log.Errorf("%+v", err)
+		data["errors"] = err.Error()
+		return context.Render(http.StatusBadRequest, "index_file.html", data)
 	}
 
-	f.Views, err = strconv.Atoi(context.FormValue("ttlViews"))
+	token, err := NewFileToken(config)
 	if err != nil {
-		log.Error("%+v\n", err)
-		DataContext["errors"] = err.Error()
-		return context.Render(http.StatusOK, "index_file.html", DataContext)
+		data["errors"] = "There was a problem during the process, please contact your administrator"
+		return context.Render(http.StatusInternalServerError, "index_file.html", data)
+	}
+
+	values, err2 := StreamFiles(config, reader, "files", token)
+	if err2 != nil {
+		data["errors"] = err2.Message
+		return context.Render(err2.Code, "index_file.html", data)
+	}
+
+	f.FileKey = token
+	// Nothing is stored yet but the archive
+	renderError := func(err *echo.HTTPError) error {
+		CleanFile(config, strings.Split(token, config.TokenSeparator)[0])
+		data["errors"] = err.Message
+		return context.Render(err.Code, "index_file.html", data)
+	}
+
+	f.Password = values.Get("password")
+
+	if f.TTL, err2 = config.FileLimits().ParseTtl(values.Get("ttl")); err2 != nil {
+		return renderError(err2)
+	}
+	if f.Views, err2 = config.FileLimits().ParseViews(values.Get("ttlViews")); err2 != nil {
+		return renderError(err2)
 	}
 
 	f.Deletable = false
-	if context.FormValue("deletable") == "on" {
+	if values.Get("deletable") == "on" {
 		f.Deletable = true
 	}
 
-	if err := context.Validate(f); err != nil {
-		log.Error("%+v\n", err)
-		DataContext["errors"] = err.Error()
-		return context.Render(http.StatusOK, "index_file.html", DataContext)
+	if f.MaxAttempts, err2 = ParseMaxAttempts(values.Get("maxAttempts")); err2 != nil {
+		return renderError(err2)
+	}
+	if f.AllowedIps, err2 = ParseAllowedIps(values.Get("allowedIps")); err2 != nil {
+		return renderError(err2)
+	}
+	if f.WebhookUrl, err2 = ParseWebhookUrl(config, values.Get("webhookUrl")); err2 != nil {
+		return renderError(err2)
 	}
+	f.WebhookSecret = values.Get("webhookSecret")
 
-	if f.TTL > 30 {
-		errorMessage := "TTL is too high"
-		log.Error(errorMessage)
-		DataContext["errors"] = errorMessage
-		return context.Render(http.StatusOK, "index_file.html", DataContext)
+	if err := context.Validate(f); err != nil {
+		log.Errorf("%+v", err)
+		return renderError(echo.NewHTTPError(http.StatusBadRequest, err.Error()))
 	}
-	f.TTL = GetTtlSeconds(f.TTL)
 
 	var provided = false
 	var passwordLink string
@@ -156,112 +206,24 @@ func AddFile(context echo.Context) error {
 	} else {
 		provided = true
 
-		token, err := SetPassword(f.Password, f.TTL, f.Views, false) // Don't give the possibility to delete the password, it will be auto deleted if the file is deleted
-		if err != nil {
-			log.Error("%+v\n", err)
-			DataContext["errors"] = err.Message
-			return context.Render(http.StatusOK, "index_file.html", DataContext)
-		}
-		f.PasswordProvidedKey = strings.Split(token, TOKEN_SEPARATOR)[0]
-		passwordLink = GetBaseUrl(context) + "/" + token
-	}
-
-	token, err := SetFile(f.Password, f.TTL, f.Views, f.Deletable, provided, f.PasswordProvidedKey)
-
-	form, err := context.MultipartForm()
-	if err != nil {
-		log.Error("%+v\n", err)
-		DataContext["errors"] = err.Error()
-		return context.Render(http.StatusOK, "index_file.html", DataContext)
-	}
-	files := form.File["files"]
-
-	if len(files) == 0 {
-		errorMessage := "No file was selected"
-		log.Error(errorMessage)
-		DataContext["errors"] = errorMessage
-		return context.Render(http.StatusOK, "index_file.html", DataContext)
-	}
-
-	folderName := strings.Split(token, TOKEN_SEPARATOR)[0]
-	folderPathName := FILEFOLDER + "/" + folderName + "/"
-	err = os.Mkdir(folderPathName, os.ModePerm)
-	if err != nil {
-		log.Error("AddFile Error while mkdir : %+v\n", err)
-		DataContext["errors"] = "There was a problem during the process, please contact your administrator"
-		return context.Render(http.StatusOK, "index_file.html", DataContext)
-	}
-
-	var fileList []string
-	var totalUploadedFileSize int64
-	for _, file := range files {
-		// Source
-		src, err := file.Open()
-		if err != nil {
-			log.Error("Error while opening file : %+v\n", err)
-			DataContext["errors"] = err.Error()
-			return context.Render(http.StatusOK, "index_file.html", DataContext)
-		}
-		defer src.Close()
-
-		if file.Size > MaxFileSize {
-			errorMessage := fmt.Sprintf("File %s is too big %d (%d mb max)", file.Filename, file.Size*1024*1024, MaxFileSize)
-			log.Error(errorMessage)
-			DataContext["errors"] = errorMessage
-			err := os.RemoveAll(folderPathName)
-			if err != nil {
-				log.Error("Failed to remove directory %s, %+v\n", folderPathName, err)
-			}
-			return context.Render(http.StatusOK, "index_file.html", DataContext)
-		}
-		totalUploadedFileSize += file.Size
-
-		// Destination
-		dst, err := os.Create(folderPathName + file.Filename)
+		passwordToken, err := SetPassword(config, f.Password, f.TTL, f.Views, false, "", 0, f.AllowedIps) // Don't give the possibility to delete the password, it will be auto deleted if the file is deleted
 		if err != nil {
-			log.Error("Error while creating file : %+v\n", err)
-			DataContext["errors"] = err.Error()
-			return context.Render(http.StatusOK, "index_file.html", DataContext)
-		}
-		defer dst.Close()
-
-		// Copy
-		if _, err = io.Copy(dst, src); err != nil {
-			log.Error("Error while copying file : %+v\n", err)
-			DataContext["errors"] = err.Error()
-			return context.Render(http.StatusOK, "index_file.html", DataContext)
+			log.Errorf("%+v", err)
+			return renderError(err)
 		}
-
-		fileList = append(fileList, folderPathName+file.Filename)
+		f.PasswordProvidedKey = strings.Split(passwordToken, config.TokenSeparator)[0]
+		passwordLink = GetBaseUrl(context) + "/" + passwordToken
 	}
 
-	if totalUploadedFileSize > MaxFileSize {
-		erroMessage := fmt.Sprintf("Total upload size (%d) is greater than %d mb (max authorized)", totalUploadedFileSize, MaxFileSize)
-		log.Error(erroMessage)
-		DataContext["errors"] = erroMessage
-		err := os.RemoveAll(folderPathName)
-		if err != nil {
-			log.Error("Failed to remove directory %s, %+v\n", folderPathName, err)
-		}
-		return context.Render(http.StatusOK, "index_file.html", DataContext)
+	if err := SetFile(config, token, f.Password, f.TTL, f.Views, f.Deletable, provided, f.PasswordProvidedKey, f.MaxAttempts, f.AllowedIps); err != nil {
+		// Remove the password share created above with the file
+		DiscardFile(config, f)
+		return renderError(err)
 	}
-
-	z := archiver.Zip{
-		CompressionLevel: flate.NoCompression,
-	}
-	err = z.Archive(fileList, FILEFOLDER+"/"+folderName+".zip")
-	if err != nil {
-		log.Error("Error while archive : %+v\n", err)
-		DataContext["errors"] = err.Error()
-		return context.Render(http.StatusOK, "index_file.html", DataContext)
-	}
-
-	err = os.RemoveAll(folderPathName)
-	if err != nil {
-		log.Error("Error while removing folder : %+v\n", err)
-		DataContext["errors"] = err.Error()
-		return context.Render(http.StatusOK, "index_file.html", DataContext)
+	if u, ok := context.Get("user").(*User); ok {
+		SetCreator(config, "file_"+strings.Split(token, config.TokenSeparator)[0], u)
 	}
+	SetWebhook(config, "file_"+strings.Split(token, config.TokenSeparator)[0], f.WebhookUrl, f.WebhookSecret, f.TTL)
 	/*File upload end*/
 
 	var (
@@ -280,31 +242,33 @@ func AddFile(context echo.Context) error {
 	f.FileKey = ""
 	f.Link = link
 	f.Password = ""
+	f.WebhookSecret = ""
 
-	DataContext["f"] = f
-	DataContext["ttl"] = GetTTLText(f.TTL)
-	DataContext["deletableText"] = deletableText
-	DataContext["deletableURL"] = deletableURL
-	DataContext["passwordLink"] = passwordLink
+	data["f"] = f
+	data["ttl"] = GetTTLText(f.TTL)
+	data["deletableText"] = deletableText
+	data["deletableURL"] = deletableURL
+	data["passwordLink"] = passwordLink
 
-	return context.Render(http.StatusOK, "confirm_file.html", DataContext)
+	return context.Render(http.StatusOK, "confirm_file.html", data)
 }
 
 func DeleteFile(context echo.Context) error {
-	delete(DataContext, "errors")
+	config := GetConfig(context)
+	data := config.DataContext()
 	f := new(File)
 	f.FileKey = context.Param("file_key")
 	if f.FileKey == "" || strings.Contains(f.FileKey, "*") {
 		return context.NoContent(http.StatusNotFound)
 	}
 
-	err := RemoveFile(f)
+	err := RemoveFile(config, f)
 	var status int
 	if err != nil {
 		status = err.Code
 		return context.NoContent(status)
 	} else {
-		DataContext["type"] = "File"
-		return context.Render(http.StatusOK, "removed.html", DataContext)
+		data["type"] = "File"
+		return context.Render(http.StatusOK, "removed.html", data)
 	}
 }
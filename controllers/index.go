@@ -6,17 +6,17 @@ import (
 	"github.com/labstack/echo"
 	log "github.com/sirupsen/logrus"
 	"net/http"
-	"strconv"
 	"strings"
 )
 
 func Index(context echo.Context) error {
-	delete(DataContext, "errors")
-	return context.Render(http.StatusOK, "set_password.html", DataContext)
+	data := GetConfig(context).DataContext()
+	return context.Render(http.StatusOK, "set_password.html", data)
 }
 
 func ReadIndex(context echo.Context) error {
-	delete(DataContext, "errors")
+	config := GetConfig(context)
+	data := config.DataContext()
 	p := new(Password)
 	p.PasswordKey = context.Param("password_key")
 
@@ -30,10 +30,14 @@ func ReadIndex(context echo.Context) error {
 		return nil
 	}
 
-	err := GetPassword(p)
+	p.ClientIp = ClientIP(context)
+	err := GetPassword(config, p)
 	if err != nil {
-		log.Error("Error while retrieving password : %s\n", err)
-		return context.Render(http.StatusNotFound, "404.html", DataContext)
+		log.Errorf("Error while retrieving password : %s", err)
+		if err.Code == http.StatusForbidden {
+			return context.String(err.Code, err.Message.(string))
+		}
+		return context.Render(http.StatusNotFound, "404.html", data)
 	}
 
 	var (
@@ -48,12 +52,12 @@ func ReadIndex(context echo.Context) error {
 		deletableURL = GetBaseUrl(context) + "/remove/" + p.PasswordKey
 	}
 
-	DataContext["p"] = p
-	DataContext["ttl"] = GetTTLText(p.TTL)
-	DataContext["deletableText"] = deletableText
-	DataContext["deletableURL"] = deletableURL
+	data["p"] = p
+	data["ttl"] = GetTTLText(p.TTL)
+	data["deletableText"] = deletableText
+	data["deletableURL"] = deletableURL
 
-	return context.Render(http.StatusOK, "password.html", DataContext)
+	return context.Render(http.StatusOK, "password.html", data)
 }
 
 func RevealPassword(context echo.Context) error {
@@ -70,33 +74,38 @@ func RevealPassword(context echo.Context) error {
 		return nil
 	}
 
-	err := RetrievePassword(p)
+	p.Passphrase = context.FormValue("passphrase")
+	p.ClientIp = ClientIP(context)
+	err := RetrievePassword(GetConfig(context), p)
 	if err != nil {
-		log.Error("%+v\n", err)
+		log.Errorf("%+v", err)
+		if err.Code == http.StatusUnauthorized || err.Code == http.StatusForbidden {
+			return context.String(err.Code, err.Message.(string))
+		}
 		return context.NoContent(http.StatusNotFound)
 	}
 
 	return context.String(200, p.Password)
 }
 
+/**
+ * Create a password share from the web form. The errors are rendered with the form and an error status, so the
+ * API key quota isn't used by a refused share.
+ */
 func AddIndex(context echo.Context) error {
-	delete(DataContext, "errors")
-	var err error
+	config := GetConfig(context)
+	data := config.DataContext()
 	p := new(Password)
 	p.Password = context.FormValue("password")
 
-	p.TTL, err = strconv.Atoi(context.FormValue("ttl"))
-	if err != nil {
-		log.Error("%+v\n", err)
-		DataContext["errors"] = err.Error()
-		return context.Render(http.StatusOK, "set_password.html", DataContext)
+	var err2 *echo.HTTPError
+	if p.TTL, err2 = config.PasswordLimits().ParseTtl(context.FormValue("ttl")); err2 != nil {
+		data["errors"] = err2.Message
+		return context.Render(http.StatusBadRequest, "set_password.html", data)
 	}
-
-	p.Views, err = strconv.Atoi(context.FormValue("ttlViews"))
-	if err != nil {
-		log.Error("%+v\n", err)
-		DataContext["errors"] = err.Error()
-		return context.Render(http.StatusOK, "set_password.html", DataContext)
+	if p.Views, err2 = config.PasswordLimits().ParseViews(context.FormValue("ttlViews")); err2 != nil {
+		data["errors"] = err2.Message
+		return context.Render(http.StatusBadRequest, "set_password.html", data)
 	}
 
 	p.Deletable = false
@@ -104,30 +113,55 @@ func AddIndex(context echo.Context) error {
 		p.Deletable = true
 	}
 
+	// Set by the javascript of the form when the password was encrypted in the browser
+	p.ClientEncrypted = context.FormValue("client_encrypted") == "true"
+	p.Passphrase = context.FormValue("passphrase")
+
+	if p.MaxAttempts, err2 = ParseMaxAttempts(context.FormValue("maxAttempts")); err2 != nil {
+		data["errors"] = err2.Message
+		return context.Render(http.StatusBadRequest, "set_password.html", data)
+	}
+	if p.AllowedIps, err2 = ParseAllowedIps(context.FormValue("allowedIps")); err2 != nil {
+		data["errors"] = err2.Message
+		return context.Render(http.StatusBadRequest, "set_password.html", data)
+	}
+	if p.WebhookUrl, err2 = ParseWebhookUrl(config, context.FormValue("webhookUrl")); err2 != nil {
+		data["errors"] = err2.Message
+		return context.Render(http.StatusBadRequest, "set_password.html", data)
+	}
+	p.WebhookSecret = context.FormValue("webhookSecret")
+
 	if err := context.Validate(p); err != nil {
-		log.Error("%+v\n", err)
-		DataContext["errors"] = "A problem occured during the processus. Please contact the administrator of the website"
-		return context.Render(http.StatusOK, "set_password.html", DataContext)
+		log.Errorf("%+v", err)
+		data["errors"] = "A problem occured during the processus. Please contact the administrator of the website"
+		return context.Render(http.StatusBadRequest, "set_password.html", data)
 	}
 
 	if p.Password == "" {
-		DataContext["errors"] = "No password provided"
-		return context.Render(http.StatusOK, "set_password.html", DataContext)
+		data["errors"] = "No password provided"
+		return context.Render(http.StatusBadRequest, "set_password.html", data)
 	}
 
-	if p.TTL > 30 {
-		DataContext["errors"] = "TTL is too high"
-		return context.Render(http.StatusOK, "set_password.html", DataContext)
+	if p.ClientEncrypted && p.Passphrase != "" {
+		data["errors"] = "A passphrase can't be used with the encryption in the browser"
+		return context.Render(http.StatusBadRequest, "set_password.html", data)
 	}
 
-	p.TTL = GetTtlSeconds(p.TTL)
-
 	// Need to use err2 since it's not an error but an httperror and it don't return nil otherwise
-	token, err2 := SetPassword(p.Password, p.TTL, p.Views, p.Deletable)
+	var token string
+	if p.ClientEncrypted {
+		token, err2 = SetClientPassword(config, p.Password, p.TTL, p.Views, p.Deletable, p.AllowedIps)
+	} else {
+		token, err2 = SetPassword(config, p.Password, p.TTL, p.Views, p.Deletable, p.Passphrase, p.MaxAttempts, p.AllowedIps)
+	}
 	if err2 != nil {
-		DataContext["errors"] = "A problem occured during the processus. Please contact the administrator of the website"
-		return context.Render(http.StatusOK, "set_password.html", DataContext)
+		data["errors"] = "A problem occured during the processus. Please contact the administrator of the website"
+		return context.Render(err2.Code, "set_password.html", data)
+	}
+	if u, ok := context.Get("user").(*User); ok {
+		SetCreator(config, strings.Split(token, config.TokenSeparator)[0], u)
 	}
+	SetWebhook(config, strings.Split(token, config.TokenSeparator)[0], p.WebhookUrl, p.WebhookSecret, p.TTL)
 
 	var (
 		deletableText,
@@ -145,30 +179,34 @@ func AddIndex(context echo.Context) error {
 	p.PasswordKey = token
 	p.Link = link
 	p.Password = ""
+	p.PassphraseProtected = p.Passphrase != ""
+	p.Passphrase = ""
+	p.WebhookSecret = ""
 
-	DataContext["p"] = p
-	DataContext["ttl"] = GetTTLText(p.TTL)
-	DataContext["deletableText"] = deletableText
-	DataContext["deletableURL"] = deletableURL
+	data["p"] = p
+	data["ttl"] = GetTTLText(p.TTL)
+	data["deletableText"] = deletableText
+	data["deletableURL"] = deletableURL
 
-	return context.Render(http.StatusOK, "confirm.html", DataContext)
+	return context.Render(http.StatusOK, "confirm.html", data)
 }
 
 func DeleteIndex(context echo.Context) error {
-	delete(DataContext, "errors")
+	config := GetConfig(context)
+	data := config.DataContext()
 	p := new(Password)
 	p.PasswordKey = context.Param("password_key")
 	if p.PasswordKey == "" || strings.Contains(p.PasswordKey, "*") {
 		return context.NoContent(http.StatusNotFound)
 	}
 
-	err := RemovePassword(p)
+	err := RemovePassword(config, p)
 	var status int
 	if err != nil {
 		status = err.Code
 		return context.NoContent(status)
 	} else {
-		DataContext["type"] = "Password"
-		return context.Render(http.StatusOK, "removed.html", DataContext)
+		data["type"] = "Password"
+		return context.Render(http.StatusOK, "removed.html", data)
 	}
 }
@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	. "github.com/eraffaelli/Okuru/models"
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Resumable uploads following the tus protocol (https://tus.io/protocols/resumable-upload.html),
+ * with the creation and termination extensions. The share options are sent in Upload-Metadata:
+ * filename, password, ttl, views, deletable, max_attempts, allowed_ips, webhook_url and webhook_secret, the links are sent in the headers of the last PATCH response.
+ */
+const tusVersion = "1.0.0"
+
+func tusHeaders(context echo.Context) {
+	context.Response().Header().Set("Tus-Resumable", tusVersion)
+	context.Response().Header().Set("Cache-Control", "no-store")
+}
+
+func tusVersionOk(context echo.Context) bool {
+	if context.Request().Header.Get("Tus-Resumable") == tusVersion {
+		return true
+	}
+	context.Response().Header().Set("Tus-Version", tusVersion)
+	return false
+}
+
+/**
+ * Upload-Metadata is a comma separated list of "key base64(value)"
+ */
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		fragments := strings.SplitN(pair, " ", 2)
+		var value []byte
+		if len(fragments) == 2 {
+			var err error
+			value, err = base64.StdEncoding.DecodeString(fragments[1])
+			if err != nil {
+				return nil, errors.New("invalid Upload-Metadata value for " + fragments[0])
+			}
+		}
+		metadata[fragments[0]] = string(value)
+	}
+	return metadata, nil
+}
+
+/**
+ * Describe the server capabilities
+ */
+func OptionsUpload(context echo.Context) error {
+	tusHeaders(context)
+	context.Response().Header().Set("Tus-Version", tusVersion)
+	context.Response().Header().Set("Tus-Extension", "creation,termination")
+	context.Response().Header().Set("Tus-Max-Size", strconv.FormatInt(GetConfig(context).MaxFileSizeBytes(), 10))
+	return context.NoContent(http.StatusNoContent)
+}
+
+/**
+ * Create an upload from Upload-Length and Upload-Metadata, the file share is created once the upload is complete.
+ */
+func CreateUpload(context echo.Context) error {
+	tusHeaders(context)
+	if !tusVersionOk(context) {
+		return context.NoContent(http.StatusPreconditionFailed)
+	}
+
+	var err error
+	config := GetConfig(context)
+	u := new(Upload)
+	u.Length, err = strconv.ParseInt(context.Request().Header.Get("Upload-Length"), 10, 64)
+	if err != nil || u.Length < 0 {
+		return context.String(http.StatusBadRequest, "Upload-Length is required")
+	}
+	if u.Length > config.MaxFileSizeBytes() {
+		return context.String(http.StatusRequestEntityTooLarge, "Upload size is greater than "+GetMaxFileSizeText(config)+" (max authorized)")
+	}
+
+	metadata, err := parseUploadMetadata(context.Request().Header.Get("Upload-Metadata"))
+	if err != nil {
+		return context.String(http.StatusBadRequest, err.Error())
+	}
+
+	if u.FileName = metadata["filename"]; u.FileName == "" {
+		u.FileName = metadata["name"]
+	}
+	if u.FileName == "" {
+		u.FileName = "file"
+	}
+	u.Password = metadata["password"]
+
+	var err2 *echo.HTTPError
+	if u.TTL, err2 = config.FileLimits().ParseTtl(metadata["ttl"]); err2 != nil {
+		return context.String(err2.Code, err2.Message.(string))
+	}
+	if u.Views, err2 = config.FileLimits().ParseViews(metadata["views"]); err2 != nil {
+		return context.String(err2.Code, err2.Message.(string))
+	}
+
+	u.Deletable, _ = strconv.ParseBool(metadata["deletable"])
+
+	if u.MaxAttempts, err2 = ParseMaxAttempts(metadata["max_attempts"]); err2 != nil {
+		return context.String(err2.Code, err2.Message.(string))
+	}
+	if u.AllowedIps, err2 = ParseAllowedIps(metadata["allowed_ips"]); err2 != nil {
+		return context.String(err2.Code, err2.Message.(string))
+	}
+	if u.WebhookUrl, err2 = ParseWebhookUrl(config, metadata["webhook_url"]); err2 != nil {
+		return context.String(err2.Code, err2.Message.(string))
+	}
+	u.WebhookSecret = metadata["webhook_secret"]
+
+	if err := SetUpload(config, u); err != nil {
+		return context.NoContent(err.Code)
+	}
+
+	context.Response().Header().Set(echo.HeaderLocation, GetBaseUrl(context)+"/api/v1/upload/"+u.UploadId+config.TokenSeparator+u.UploadKey)
+	if u.Length == 0 {
+		unlock := LockUpload(u.UploadId)
+		defer unlock()
+		if err := completeUpload(context, u); err != nil {
+			return context.NoContent(err.Code)
+		}
+	}
+	return context.NoContent(http.StatusCreated)
+}
+
+/**
+ * Return the offset to resume the upload from
+ */
+func HeadUpload(context echo.Context) error {
+	tusHeaders(context)
+	if !tusVersionOk(context) {
+		return context.NoContent(http.StatusPreconditionFailed)
+	}
+
+	u, err := uploadOf(context)
+	if err != nil {
+		return context.NoContent(err.Code)
+	}
+	if err := GetUpload(GetConfig(context), u); err != nil {
+		return context.NoContent(err.Code)
+	}
+
+	context.Response().Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	context.Response().Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	return context.NoContent(http.StatusOK)
+}
+
+/**
+ * Append a chunk at Upload-Offset, the last one turns the upload into a file share.
+ */
+func PatchUpload(context echo.Context) error {
+	tusHeaders(context)
+	if !tusVersionOk(context) {
+		return context.NoContent(http.StatusPreconditionFailed)
+	}
+	if context.Request().Header.Get(echo.HeaderContentType) != "application/offset+octet-stream" {
+		return context.NoContent(http.StatusUnsupportedMediaType)
+	}
+	offset, err := strconv.ParseInt(context.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return context.String(http.StatusBadRequest, "Upload-Offset is required")
+	}
+
+	u, err2 := uploadOf(context)
+	if err2 != nil {
+		return context.NoContent(err2.Code)
+	}
+	unlock := LockUpload(u.UploadId)
+	defer unlock()
+
+	if err := GetUpload(GetConfig(context), u); err != nil {
+		return context.NoContent(err.Code)
+	}
+	if offset != u.Offset || u.Offset == u.Length {
+		return context.NoContent(http.StatusConflict)
+	}
+
+	if err := WriteUpload(GetConfig(context), u, context.Request().Body); err != nil {
+		return context.NoContent(err.Code)
+	}
+	context.Response().Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+
+	if u.Offset == u.Length {
+		if err := completeUpload(context, u); err != nil {
+			return context.NoContent(err.Code)
+		}
+	}
+	return context.NoContent(http.StatusNoContent)
+}
+
+/**
+ * The upload of the url, its id and the key of its password and webhook secret
+ */
+func uploadOf(context echo.Context) (*Upload, *echo.HTTPError) {
+	var err error
+	u := new(Upload)
+	u.UploadId, u.UploadKey, err = ParseToken(GetConfig(context), context.Param("upload_id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound)
+	}
+	return u, nil
+}
+
+/**
+ * Create the file share of a complete upload and send its links in the response headers
+ */
+func completeUpload(context echo.Context, u *Upload) *echo.HTTPError {
+	token, passwordToken, err := CompleteUpload(GetConfig(context), u)
+	if err != nil {
+		return err
+	}
+
+	baseUrl := GetBaseUrl(context) + "/"
+	context.Response().Header().Set("Okuru-Link", baseUrl+"file/"+token)
+	context.Response().Header().Set("Okuru-Link-Api", baseUrl+"api/v1/file/"+token)
+	if passwordToken != "" {
+		context.Response().Header().Set("Okuru-Password-Link", baseUrl+passwordToken)
+	}
+	return nil
+}
+
+/**
+ * Abort an upload and remove what was received
+ */
+func DeleteUpload(context echo.Context) error {
+	tusHeaders(context)
+	if !tusVersionOk(context) {
+		return context.NoContent(http.StatusPreconditionFailed)
+	}
+
+	u, err := uploadOf(context)
+	if err != nil {
+		return context.NoContent(err.Code)
+	}
+	unlock := LockUpload(u.UploadId)
+	defer unlock()
+
+	if err := GetUpload(GetConfig(context), u); err != nil {
+		return context.NoContent(err.Code)
+	}
+	RemoveUpload(GetConfig(context), u.UploadId)
+	return context.NoContent(http.StatusNoContent)
+}
@@ -4,33 +4,27 @@ package main
 //https://github.com/verybluebot/echo-server-tutorial/
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"github.com/eraffaelli/Okuru/router"
 	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/pflag"
 	"math/rand"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 )
 
-var DebugLevel bool
-
-func Flags() {
-	pflag.BoolVar(&DebugLevel, "debug", false, "--debug")
-	defer pflag.Parse()
-	return
-}
-
-func init() {
-	Flags()
-
-	pool := NewPool()
-	c := pool.Get()
-	defer c.Close()
-	if Ping(c) == false {
-		log.Panic("Redis problem")
-	}
-
+/**
+ * Set up the logs and open the services of the configuration: the store, the blob storage, the OpenID Connect
+ * provider and the rate limiter
+ */
+func setup(config *Config) (Services, error) {
 	// Log as JSON instead of the default ASCII formatter.
 	log.SetFormatter(&log.JSONFormatter{})
 
@@ -38,20 +32,137 @@ func init() {
 	// Can be any io.Writer, see below for File example
 	log.SetOutput(os.Stdout)
 
-
-	if DebugLevel == true {
+	if config.Debug == true {
 		log.SetLevel(log.DebugLevel)
 	} else {
 		log.SetLevel(log.WarnLevel)
 	}
+	log.Debugf("Configuration : %+v", config.Redacted())
+	if config.BaseUrl == "" {
+		log.Warn("OKURU_BASE_URL is not set, the links are made from the Host header of the requests")
+	}
+
+	return OpenServices(config)
+}
+
+/**
+ * Serve on app_port, in https when tls is set, and redirect the http port to it. The servers errors are sent to serving.
+ */
+func serve(e *echo.Echo, config *Config, tlsConfig *tls.Config, redirect http.Handler, serving chan<- error) *http.Server {
+	address := ":" + strconv.Itoa(config.AppPort)
+	go func() {
+		if tlsConfig == nil {
+			serving <- e.Start(address)
+			return
+		}
+		e.TLSServer.Addr = address
+		e.TLSServer.TLSConfig = tlsConfig
+		serving <- e.StartServer(e.TLSServer)
+	}()
+
+	if tlsConfig == nil || config.HttpPort == 0 {
+		return nil
+	}
+	redirectServer := &http.Server{Addr: ":" + strconv.Itoa(config.HttpPort), Handler: redirect}
+	go func() {
+		serving <- redirectServer.ListenAndServe()
+	}()
+	return redirectServer
+}
+
+/**
+ * Stop the servers once the requests in progress (uploads included) are done, then the expired keys watcher and the
+ * webhook deliveries, before closing the services. Everything must be done within ctx.
+ */
+func shutdown(ctx context.Context, e *echo.Echo, services Services, redirectServer *http.Server, stopWatch context.CancelFunc, watching <-chan struct{}) {
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(ctx); err != nil {
+			log.Error("Shutdown() redirect server : ", err)
+		}
+	}
+	if err := e.Shutdown(ctx); err != nil {
+		log.Error("Shutdown() requests still in progress : ", err)
+	}
 
-	go CleanFileWatch()
+	stopWatch()
+	if err := wait(ctx, watching); err != nil {
+		log.Error("Shutdown() expired keys watcher still running : ", err)
+	}
+
+	StopDeliveries()
+	delivered := make(chan struct{})
+	go func() {
+		Deliveries.Wait()
+		close(delivered)
+	}()
+	if err := wait(ctx, delivered); err != nil {
+		log.Error("Shutdown() webhook deliveries still in progress, they are lost : ", err)
+	}
+
+	if err := services.Close(); err != nil {
+		log.Error("Shutdown() services close : ", err)
+	}
+}
+
+func wait(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
-	e:= router.New()
+	config, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "okuru:", err)
+		os.Exit(2)
+	}
+	tlsConfig, redirect, err := NewTLS(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "okuru:", err)
+		os.Exit(1)
+	}
+	services, err := setup(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "okuru:", err)
+		os.Exit(1)
+	}
+	e, err := router.New(config, services)
+	if err != nil {
+		services.Close()
+		fmt.Fprintln(os.Stderr, "okuru:", err)
+		os.Exit(1)
+	}
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	watching := make(chan struct{})
+	go func() {
+		CleanFileWatch(watchCtx, config)
+		close(watching)
+	}()
 
-	e.Logger.Fatal(e.Start(":" + APP_PORT))
-}
\ No newline at end of file
+	serving := make(chan error, 2)
+	redirectServer := serve(e, config, tlsConfig, redirect, serving)
+
+	code := 0
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case sig := <-signals:
+		log.Warn("Received ", sig, ", shutting down")
+	case err := <-serving:
+		// A server only stops by itself when it can't listen
+		log.Error("Server stopped : ", err)
+		code = 1
+	}
+	signal.Stop(signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout)*time.Second)
+	shutdown(ctx, e, config.Services, redirectServer, stopWatch, watching)
+	cancel()
+	os.Exit(code)
+}
@@ -0,0 +1,84 @@
+package middlewares
+
+import (
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Header of the API key, "Authorization: Bearer <key>" is accepted too
+	ApiKeyHeader = "Okuru-Api-Key"
+	// Cookie set by the web forms, a browser can't add a header to a form
+	ApiKeyCookie = "okuru_api_key"
+)
+
+/**
+ * Require an API key when OKURU_API_KEYS is enabled, with quota a successful request is counted on the quota of the
+ * key, the refused ones (4xx, 5xx) aren't. The key is available to the handlers with context.Get("api_key").
+ */
+func ApiKeyAuth(config *Config, quota bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(context echo.Context) error {
+			if !config.ApiKeys {
+				return next(context)
+			}
+
+			k, err := UseApiKey(config, apiKeyOf(context), quota)
+			if err != nil {
+				if err.Code == http.StatusTooManyRequests {
+					now := time.Now().UTC()
+					tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+					context.Response().Header().Set("Retry-After", strconv.Itoa(int(tomorrow.Sub(now).Seconds())+1))
+				}
+				return err
+			}
+			context.Set("api_key", k)
+			if err := next(context); err != nil {
+				return err
+			}
+			if status := context.Response().Status; quota && status >= 200 && status < 300 {
+				CountApiKey(config, k)
+			}
+			return nil
+		}
+	}
+}
+
+/**
+ * For the web forms creating a share when both OKURU_OIDC_ISSUER and OKURU_API_KEYS are set: a login session or an
+ * API key is enough, the key is only needed without session. With only one of them, it's required as usual.
+ */
+func RequireLoginOrApiKey(config *Config, quota bool) echo.MiddlewareFunc {
+	requireLogin := RequireLogin(config)
+	apiKeyAuth := ApiKeyAuth(config, quota)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withLogin := requireLogin(next)
+		withApiKey := apiKeyAuth(next)
+		return func(context echo.Context) error {
+			if config.Services.OIDC == nil {
+				return withApiKey(context)
+			}
+			if config.ApiKeys && GetSession(context) == nil && apiKeyOf(context) != "" {
+				return withApiKey(context)
+			}
+			return withLogin(context)
+		}
+	}
+}
+
+func apiKeyOf(context echo.Context) string {
+	if key := context.Request().Header.Get(ApiKeyHeader); key != "" {
+		return key
+	}
+	if auth := context.Request().Header.Get(echo.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if cookie, err := context.Cookie(ApiKeyCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
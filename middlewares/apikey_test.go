@@ -0,0 +1,125 @@
+package middlewares
+
+import (
+	. "github.com/eraffaelli/Okuru/models"
+	"github.com/eraffaelli/Okuru/store"
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApiKeyQuota(t *testing.T) {
+	config := DefaultConfig()
+	config.ApiKeys = true
+	config.Services.Store = store.NewMemory()
+	key, k, err := NewApiKey(config, "test", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Pre(WithConfig(config))
+	e.POST("/:status", func(context echo.Context) error {
+		if context.Param("status") == "created" {
+			return context.NoContent(http.StatusCreated)
+		}
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}, ApiKeyAuth(config, true))
+
+	post := func(target string) int {
+		request := httptest.NewRequest(http.MethodPost, target, nil)
+		request.Header.Set(ApiKeyHeader, key)
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := post("/refused"); code != http.StatusBadRequest {
+			t.Fatalf("refused request %d code = %d, want 400", i, code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if code := post("/created"); code != http.StatusCreated {
+			t.Fatalf("share %d code = %d, want 201, the refused requests must not use the quota", i, code)
+		}
+	}
+	if code := post("/created"); code != http.StatusTooManyRequests {
+		t.Errorf("share over the quota code = %d, want 429", code)
+	}
+
+	k, err = GetApiKey(config, k.Id)
+	if err != nil || UsedToday(k) != 2 {
+		t.Errorf("GetApiKey = %+v, %v, want 2 shares used today", k, err)
+	}
+	k.Day = "2000-01-01"
+	if UsedToday(k) != 0 {
+		t.Errorf("UsedToday of a key last used on another day = %d, want 0", UsedToday(k))
+	}
+}
+
+/**
+ * Without OpenID Connect, RequireLoginOrApiKey is ApiKeyAuth. With it, a session is enough and a key without session
+ * too, but one of them is needed.
+ */
+func TestRequireLoginOrApiKey(t *testing.T) {
+	config := DefaultConfig()
+	config.ApiKeys = true
+	config.Services.Store = store.NewMemory()
+	key, _, err := NewApiKey(config, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Pre(WithConfig(config))
+	e.POST("/", func(context echo.Context) error {
+		return context.NoContent(http.StatusCreated)
+	}, RequireLoginOrApiKey(config, true))
+	e.POST("/login", func(context echo.Context) error {
+		if err := SetSession(context, &User{Subject: "user-1", Email: "user@example.com"}); err != nil {
+			return err
+		}
+		return context.NoContent(http.StatusNoContent)
+	})
+
+	post := func(apiKey string, cookies ...*http.Cookie) int {
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		if apiKey != "" {
+			request.Header.Set(ApiKeyHeader, apiKey)
+		}
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := post(""); code != http.StatusUnauthorized {
+		t.Errorf("without key code = %d, want 401", code)
+	}
+	if code := post(key); code != http.StatusCreated {
+		t.Errorf("with a key code = %d, want 201", code)
+	}
+
+	config.Services.OIDC = &OIDCAuth{}
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/login", nil))
+	session := recorder.Result().Cookies()
+
+	if code := post(""); code != http.StatusUnauthorized {
+		t.Errorf("without session nor key code = %d, want 401", code)
+	}
+	if code := post("", session...); code != http.StatusCreated {
+		t.Errorf("with a session code = %d, want 201", code)
+	}
+	if code := post(key); code != http.StatusCreated {
+		t.Errorf("with a key code = %d, want 201", code)
+	}
+	if code := post("okuru_invalid"); code != http.StatusUnauthorized {
+		t.Errorf("with an invalid key code = %d, want 401", code)
+	}
+}
@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+	"strings"
+)
+
+/**
+ * Serve the routes under the path of OKURU_BASE_URL. The requests that still start with it are routed without it,
+ * the others are routed as they are, for the reverse proxies that remove the path themselves.
+ */
+func StripBasePath(config *Config) echo.MiddlewareFunc {
+	basePath := config.BasePath()
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(context echo.Context) error {
+			u := context.Request().URL
+			if basePath == "" || (u.Path != basePath && !strings.HasPrefix(u.Path, basePath+"/")) {
+				return next(context)
+			}
+
+			u.Path = strings.TrimPrefix(u.Path, basePath)
+			if u.Path == "" {
+				u.Path = "/"
+			}
+			if strings.HasPrefix(u.RawPath, basePath) {
+				u.RawPath = strings.TrimPrefix(u.RawPath, basePath)
+			} else {
+				u.RawPath = ""
+			}
+			return next(context)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package middlewares
+
+import (
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+)
+
+/**
+ * Make the configuration available to the handlers with GetConfig(context), it must run before the other middlewares.
+ */
+func WithConfig(config *Config) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(context echo.Context) error {
+			SetConfig(context, config)
+			return next(context)
+		}
+	}
+}
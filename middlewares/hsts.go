@@ -0,0 +1,23 @@
+package middlewares
+
+import (
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+	"strconv"
+)
+
+/**
+ * Send Strict-Transport-Security with OKURU_HSTS_MAX_AGE on the https responses. X-Forwarded-Proto is only believed
+ * from the proxies of OKURU_TRUSTED_PROXIES, any client could otherwise pin a host that may not serve https.
+ */
+func Hsts(config *Config) echo.MiddlewareFunc {
+	value := "max-age=" + strconv.Itoa(config.HstsMaxAge)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(context echo.Context) error {
+			if IsHttps(context) {
+				context.Response().Header().Set("Strict-Transport-Security", value)
+			}
+			return next(context)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"crypto/tls"
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHsts(t *testing.T) {
+	config := DefaultConfig()
+	config.HstsMaxAge = 600
+	config.TrustedProxies = []string{"10.0.0.0/8"}
+
+	e := echo.New()
+	e.Pre(WithConfig(config))
+	e.Use(Hsts(config))
+	e.GET("/", func(context echo.Context) error {
+		return context.NoContent(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		proto      string
+		want       string
+	}{
+		{name: "tls", remoteAddr: "192.0.2.1:1234", tls: true, want: "max-age=600"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:1234", proto: "https", want: "max-age=600"},
+		{name: "trusted proxy in http", remoteAddr: "10.0.0.1:1234", proto: "http"},
+		{name: "untrusted proxy", remoteAddr: "192.0.2.1:1234", proto: "https"},
+		{name: "http", remoteAddr: "192.0.2.1:1234"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = test.remoteAddr
+		if test.tls {
+			request.TLS = &tls.ConnectionState{}
+		}
+		if test.proto != "" {
+			request.Header.Set(echo.HeaderXForwardedProto, test.proto)
+		}
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		if got := recorder.Header().Get("Strict-Transport-Security"); got != test.want {
+			t.Errorf("%s: Strict-Transport-Security = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
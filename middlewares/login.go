@@ -0,0 +1,32 @@
+package middlewares
+
+import (
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+	"net/http"
+	"net/url"
+)
+
+/**
+ * Require an OpenID Connect login when OKURU_OIDC_ISSUER is set. The pages redirect to the login, the forms answer 401
+ * since what was posted would be lost anyway. The user is available to the handlers with context.Get("user").
+ */
+func RequireLogin(config *Config) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(context echo.Context) error {
+			if config.Services.OIDC == nil {
+				return next(context)
+			}
+
+			u := GetSession(context)
+			if u == nil {
+				if context.Request().Method == http.MethodGet {
+					return context.Redirect(http.StatusFound, config.BasePath()+"/auth/login?next="+url.QueryEscape(context.Request().URL.RequestURI()))
+				}
+				return echo.NewHTTPError(http.StatusUnauthorized, "Your session expired, log in again")
+			}
+			context.Set("user", u)
+			return next(context)
+		}
+	}
+}
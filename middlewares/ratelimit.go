@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+/**
+ * Limit the shares creation per client IP, OKURU_RATE_LIMIT_CREATE requests per minute
+ */
+func CreateLimit(config *Config) echo.MiddlewareFunc {
+	return RateLimit("create", config.RateLimitCreate)
+}
+
+/**
+ * Limit the attempts to reveal a password or download a file per client IP, OKURU_RATE_LIMIT_REVEAL requests per minute
+ */
+func RevealLimit(config *Config) echo.MiddlewareFunc {
+	return RateLimit("reveal", config.RateLimitReveal)
+}
+
+/**
+ * Limit the file uploads per client IP, OKURU_RATE_LIMIT_UPLOAD requests per minute
+ */
+func UploadLimit(config *Config) echo.MiddlewareFunc {
+	return RateLimit("upload", config.RateLimitUpload)
+}
+
+/**
+ * Allow rate requests per minute and per client IP, counted in the budget name.
+ * Over it, 429 is returned with the seconds to wait in Retry-After. If the limiter fails, the request is let through.
+ */
+func RateLimit(name string, rate int) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(context echo.Context) error {
+			limiter := GetConfig(context).Services.Limiter
+			if limiter == nil {
+				return next(context)
+			}
+
+			ok, wait, err := limiter.Take(name+"_"+ClientIP(context), rate)
+			if err != nil {
+				log.Error("RateLimit() Limiter err take : ", err)
+				return next(context)
+			}
+			if !ok {
+				retryAfter := int(math.Ceil(wait.Seconds()))
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				context.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				return echo.NewHTTPError(http.StatusTooManyRequests, "Too many requests, retry in "+strconv.Itoa(retryAfter)+" seconds")
+			}
+			return next(context)
+		}
+	}
+}
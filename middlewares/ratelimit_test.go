@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	. "github.com/eraffaelli/Okuru/utils"
+	"github.com/labstack/echo"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	config := DefaultConfig()
+	config.Services.Limiter = NewMemoryLimiter()
+
+	e := echo.New()
+	e.Pre(WithConfig(config))
+	e.GET("/", func(context echo.Context) error {
+		return context.NoContent(http.StatusNoContent)
+	}, RateLimit("reveal", 2))
+
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	for i := 0; i < 2; i++ {
+		if recorder := get("192.0.2.1:1234"); recorder.Code != http.StatusNoContent {
+			t.Fatalf("request %d code = %d, want 204", i, recorder.Code)
+		}
+	}
+	recorder := get("192.0.2.1:1234")
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the rate code = %d, want 429", recorder.Code)
+	}
+	retryAfter, err := strconv.Atoi(recorder.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 30 {
+		t.Errorf("Retry-After = %q, want 1 to 30 seconds", recorder.Header().Get("Retry-After"))
+	}
+
+	if recorder := get("192.0.2.2:1234"); recorder.Code != http.StatusNoContent {
+		t.Errorf("request of another client code = %d, want 204", recorder.Code)
+	}
+}
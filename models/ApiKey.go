@@ -0,0 +1,10 @@
+package models
+
+type ApiKey struct {
+	Id string `json:"id" xml:"id"`
+	Label string `json:"label,omitempty" xml:"label,omitempty" redis:"label,omitempty"`
+	Quota int `json:"quota" xml:"quota" redis:"quota"`
+	Used int `json:"used" xml:"used" redis:"used"`
+	Day string `json:"-" xml:"-" redis:"day,omitempty"`
+	CreatedAt string `json:"created_at,omitempty" xml:"created_at,omitempty" redis:"created_at,omitempty"`
+}
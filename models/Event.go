@@ -0,0 +1,9 @@
+package models
+
+type Event struct {
+	Event string `json:"event" xml:"event"`
+	Type string `json:"type" xml:"type"`
+	Id string `json:"id" xml:"id"`
+	ViewsLeft int `json:"views_left" xml:"views_left"`
+	Time string `json:"time" xml:"time"`
+}
@@ -2,12 +2,20 @@ package models
 
 type File struct {
 	Password string `json:"password,omitempty" xml:"password,omitempty" form:"password,omitempty" query:"password,omitempty" redis:"password,omitempty"`
-	PasswordProvided bool `redis:"provided,omitempty"`
-	PasswordProvidedKey string `redis:"provided_key,omitempty"`
-	Token []byte `redis:"token,omitempty"`
+	PasswordProvided bool `json:"password_provided,omitempty" xml:"password_provided,omitempty" redis:"provided,omitempty"`
+	PasswordProvidedKey string `json:"-" xml:"-" redis:"provided_key,omitempty"`
+	PasswordLink string `json:"password_link,omitempty" xml:"password_link,omitempty"`
+	Token []byte `json:"-" xml:"-" redis:"token,omitempty"`
 	TTL int `json:"ttl,omitempty" xml:"ttl,omitempty" form:"ttl,omitempty" query:"ttl,omitempty" redis:"ttl,omitempty"`
 	Views int `json:"views,omitempty" xml:"views,omitempty" form:"views,omitempty" query:"views,omitempty" redis:"views,omitempty"`
-	ViewsCount int `redis:"views_count,omitempty"`
+	ViewsCount int `json:"-" xml:"-" redis:"views_count,omitempty"`
+	MaxAttempts int `json:"max_attempts,omitempty" xml:"max_attempts,omitempty" form:"max_attempts,omitempty" query:"max_attempts,omitempty" redis:"max_attempts,omitempty"`
+	FailedAttempts int `json:"-" xml:"-" redis:"failed_attempts,omitempty"`
+	AllowedIps string `json:"allowed_ips,omitempty" xml:"allowed_ips,omitempty" form:"allowed_ips,omitempty" query:"allowed_ips,omitempty" redis:"allowed_ips,omitempty"`
+	ClientIp string `json:"-" xml:"-"`
+	WebhookUrl string `json:"webhook_url,omitempty" xml:"webhook_url,omitempty" form:"webhook_url,omitempty" query:"webhook_url,omitempty"`
+	WebhookSecret string `json:"webhook_secret,omitempty" xml:"-" form:"webhook_secret,omitempty" query:"webhook_secret,omitempty"`
+	Creator string `json:"-" xml:"-" redis:"creator,omitempty"`
 	Deletable bool `json:"deletable,omitempty" xml:"deletable,omitempty" form:"deletable,omitempty" query:"deletable,omitempty" redis:"deletable,omitempty"`
 	FileKey string `json:"file_key,omitempty" xml:"file_key,omitempty" form:"file_key,omitempty" query:"password_key,omitempty"`
 	Link string `json:"link,omitempty" xml:"link,omitempty" form:"link,omitempty" query:"link,omitempty"`
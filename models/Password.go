@@ -7,6 +7,17 @@ type Password struct {
 	Views int `json:"views,omitempty" xml:"views,omitempty" form:"views,omitempty" query:"views,omitempty" redis:"views,omitempty"`
 	ViewsCount int `json:"views_count,omitempty" xml:"views_count,omitempty" form:"views_count,omitempty" query:"views_count,omitempty" redis:"views_count,omitempty"`
 	Deletable bool `json:"deletable,omitempty" xml:"deletable,omitempty" form:"deletable,omitempty" query:"deletable,omitempty" redis:"deletable,omitempty"`
+	Passphrase string `json:"passphrase,omitempty" xml:"passphrase,omitempty" form:"passphrase,omitempty" query:"passphrase,omitempty"`
+	PassphraseProtected bool `json:"passphrase_protected,omitempty" xml:"passphrase_protected,omitempty" redis:"passphrase,omitempty"`
+	Salt string `json:"-" xml:"-" redis:"salt,omitempty"`
+	AllowedIps string `json:"allowed_ips,omitempty" xml:"allowed_ips,omitempty" form:"allowed_ips,omitempty" query:"allowed_ips,omitempty" redis:"allowed_ips,omitempty"`
+	ClientIp string `json:"-" xml:"-"`
+	WebhookUrl string `json:"webhook_url,omitempty" xml:"webhook_url,omitempty" form:"webhook_url,omitempty" query:"webhook_url,omitempty"`
+	WebhookSecret string `json:"webhook_secret,omitempty" xml:"-" form:"webhook_secret,omitempty" query:"webhook_secret,omitempty"`
+	Creator string `json:"-" xml:"-" redis:"creator,omitempty"`
+	MaxAttempts int `json:"max_attempts,omitempty" xml:"max_attempts,omitempty" form:"max_attempts,omitempty" query:"max_attempts,omitempty" redis:"max_attempts,omitempty"`
+	FailedAttempts int `json:"-" xml:"-" redis:"failed_attempts,omitempty"`
+	ClientEncrypted bool `json:"client_encrypted,omitempty" xml:"client_encrypted,omitempty" form:"client_encrypted,omitempty" query:"client_encrypted,omitempty" redis:"client_encrypted,omitempty"`
 	PasswordKey string `json:"password_key,omitempty" xml:"password_key,omitempty" form:"password_key,omitempty" query:"password_key,omitempty"`
 	Link string `json:"link,omitempty" xml:"link,omitempty" form:"link,omitempty" query:"link,omitempty"`
 	LinkApi string `json:"link_api,omitempty" xml:"link_api,omitempty" form:"link_api,omitempty" query:"link_api,omitempty"`
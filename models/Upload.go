@@ -0,0 +1,17 @@
+package models
+
+type Upload struct {
+	UploadId string `json:"upload_id,omitempty" xml:"upload_id,omitempty"`
+	UploadKey string `json:"-" xml:"-" redis:"-"`
+	Length int64 `json:"length" xml:"length" redis:"length"`
+	Offset int64 `json:"offset" xml:"offset" redis:"offset"`
+	FileName string `json:"filename,omitempty" xml:"filename,omitempty" redis:"filename,omitempty"`
+	Password string `json:"-" xml:"-" redis:"password,omitempty"`
+	TTL int `json:"ttl,omitempty" xml:"ttl,omitempty" redis:"ttl,omitempty"`
+	Views int `json:"views,omitempty" xml:"views,omitempty" redis:"views,omitempty"`
+	MaxAttempts int `json:"max_attempts,omitempty" xml:"max_attempts,omitempty" redis:"max_attempts,omitempty"`
+	AllowedIps string `json:"allowed_ips,omitempty" xml:"allowed_ips,omitempty" redis:"allowed_ips,omitempty"`
+	WebhookUrl string `json:"-" xml:"-" redis:"webhook_url,omitempty"`
+	WebhookSecret string `json:"-" xml:"-" redis:"webhook_secret,omitempty"`
+	Deletable bool `json:"deletable,omitempty" xml:"deletable,omitempty" redis:"deletable,omitempty"`
+}
@@ -0,0 +1,8 @@
+package models
+
+type User struct {
+	Subject string `json:"sub"`
+	Email string `json:"email,omitempty"`
+	Name string `json:"name,omitempty"`
+	Expire int64 `json:"exp"`
+}
@@ -0,0 +1,6 @@
+package models
+
+type Webhook struct {
+	Url string `json:"url,omitempty" xml:"url,omitempty" redis:"url,omitempty"`
+	Secret string `json:"-" xml:"-" redis:"secret,omitempty"`
+}
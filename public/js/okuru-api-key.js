@@ -0,0 +1,20 @@
+/*
+ * Servers requiring an API key read it from a cookie for the web forms, a form can't send a header.
+ * The key is remembered by the browser so it only has to be typed once.
+ */
+(function() {
+    let input = document.getElementById("api_key");
+    if (!input) {
+        return;
+    }
+
+    let saved = document.cookie.match(/(?:^|; )okuru_api_key=([^;]*)/);
+    if (saved) {
+        input.value = decodeURIComponent(saved[1]);
+    }
+
+    input.form.addEventListener("submit", function() {
+        document.cookie = "okuru_api_key=" + encodeURIComponent(input.value.trim()) + "; path=/; max-age=31536000; SameSite=Strict" +
+            (location.protocol === "https:" ? "; Secure" : "");
+    });
+})();
@@ -0,0 +1,50 @@
+/*
+ * Client side encryption of the secrets. The key is only kept in the URL fragment, which browsers never send to the server.
+ * The server gets base64(12 bytes nonce + AES-256-GCM ciphertext), the key is encoded in base64url without padding.
+ */
+const OkuruCrypto = {
+    available: function() {
+        return !!(window.crypto && window.crypto.subtle);
+    },
+
+    toBase64: function(bytes) {
+        let binary = "";
+        bytes.forEach(function(b) { binary += String.fromCharCode(b); });
+        return btoa(binary);
+    },
+
+    fromBase64: function(text) {
+        return Uint8Array.from(atob(text), function(c) { return c.charCodeAt(0); });
+    },
+
+    encodeKey: function(bytes) {
+        return OkuruCrypto.toBase64(bytes).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+    },
+
+    decodeKey: function(text) {
+        text = text.replace(/-/g, "+").replace(/_/g, "/");
+        while (text.length % 4 !== 0) {
+            text += "=";
+        }
+        return OkuruCrypto.fromBase64(text);
+    },
+
+    // Resolve with {ciphertext, key}
+    encrypt: async function(plaintext) {
+        const rawKey = window.crypto.getRandomValues(new Uint8Array(32)),
+            nonce = window.crypto.getRandomValues(new Uint8Array(12)),
+            key = await window.crypto.subtle.importKey("raw", rawKey, "AES-GCM", false, ["encrypt"]),
+            sealed = new Uint8Array(await window.crypto.subtle.encrypt({name: "AES-GCM", iv: nonce}, key, new TextEncoder().encode(plaintext))),
+            payload = new Uint8Array(nonce.length + sealed.length);
+        payload.set(nonce);
+        payload.set(sealed, nonce.length);
+        return {ciphertext: OkuruCrypto.toBase64(payload), key: OkuruCrypto.encodeKey(rawKey)};
+    },
+
+    decrypt: async function(ciphertext, encodedKey) {
+        const payload = OkuruCrypto.fromBase64(ciphertext),
+            key = await window.crypto.subtle.importKey("raw", OkuruCrypto.decodeKey(encodedKey), "AES-GCM", false, ["decrypt"]),
+            plain = await window.crypto.subtle.decrypt({name: "AES-GCM", iv: payload.slice(0, 12)}, key, payload.slice(12));
+        return new TextDecoder().decode(plain);
+    }
+};
@@ -2,7 +2,10 @@ package router
 
 import (
 	"errors"
+	"fmt"
+	"github.com/eraffaelli/Okuru/middlewares"
 	"github.com/eraffaelli/Okuru/routes"
+	. "github.com/eraffaelli/Okuru/utils"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
@@ -32,15 +35,6 @@ func (r Renderer) Render(w io.Writer, name string, data interface{}, c echo.Cont
 	var t *pongo2.Template
 	var err error
 
-	ex, err := os.Executable()
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = pongo2.DefaultLoader.SetBaseDir(filepath.Dir(ex) + "/views")
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	if data != nil {
 		var ok bool
 		ctx, ok = data.(pongo2.Context)
@@ -65,15 +59,31 @@ func (r Renderer) Render(w io.Writer, name string, data interface{}, c echo.Cont
 
 	//return t.ExecuteWriter(ctx, w)
 	result := t.ExecuteWriter(ctx, w)
-	log.Debug("%+v\n", result)
+	log.Debugf("%+v", result)
 	return result
 }
 
-func New() *echo.Echo {
+/**
+ * Create the server with its routes from a validated configuration and its services, kept in config.Services for the
+ * handlers. The views and public folders are next to the executable.
+ */
+func New(config *Config, services Services) (*echo.Echo, error) {
+	config.Services = services
+	ex, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	err = pongo2.DefaultLoader.SetBaseDir(filepath.Dir(ex) + "/views")
+	if err != nil {
+		return nil, fmt.Errorf("views problem : %w", err)
+	}
+
 	renderer := Renderer{
 		Debug: true,
 	}
 	e := echo.New()
+	e.Pre(middlewares.WithConfig(config))
+	e.Pre(middlewares.StripBasePath(config))
 	e.Pre(middleware.RemoveTrailingSlash())
 	e.Renderer = renderer
 	e.Validator = &CustomValidator{validator: validator.New()}
@@ -85,25 +95,34 @@ func New() *echo.Echo {
 			`"bytes_out":${bytes_out}` +
 			`"user_agent":${user_agent}}` + "\n",
 	}))
+	// Strict-Transport-Security, only when Okuru serves https itself or a trusted proxy can say the request is https
+	if config.HstsMaxAge > 0 && (config.TlsEnabled() || len(config.TrustedProxies) > 0) {
+		e.Use(middlewares.Hsts(config))
+	}
 	//CORS
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"*"},
-		AllowMethods: []string{echo.GET, echo.HEAD, echo.OPTIONS, echo.POST, echo.DELETE},
+		AllowMethods: []string{echo.GET, echo.HEAD, echo.OPTIONS, echo.POST, echo.PATCH, echo.DELETE},
+		// Needed by the browser tus clients
+		ExposeHeaders: []string{echo.HeaderLocation, "Upload-Offset", "Upload-Length", "Tus-Resumable", "Tus-Version",
+			"Tus-Extension", "Tus-Max-Size", "Okuru-Link", "Okuru-Link-Api", "Okuru-Password-Link"},
 	}))
 
 	// Creating groups
 	apiGroup := e.Group("/api/v1")
+	apiFileGroup := e.Group("/api/v1/file")
+	apiUploadGroup := e.Group("/api/v1/upload")
+	authGroup := e.Group("/auth")
 	fileGroup := e.Group("/file")
 
 	//Route => handler
-	ex, err := os.Executable()
-	if err != nil {
-		log.Fatal(err)
-	}
 	e.Static("/", filepath.Dir(ex)+"/public") //this need to be before routing
-	routes.Index(e)
-	routes.Password(apiGroup)
-	routes.File(fileGroup)
-
-	return e
+	routes.Index(e, config)
+	routes.Password(apiGroup, config)
+	routes.FileApi(apiFileGroup, config)
+	routes.Upload(apiUploadGroup, config)
+	routes.File(fileGroup, config)
+	routes.Auth(authGroup)
+
+	return e, nil
 }
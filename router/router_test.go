@@ -0,0 +1,194 @@
+package router
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/eraffaelli/Okuru/blob"
+	"github.com/eraffaelli/Okuru/store"
+	"github.com/eraffaelli/Okuru/utils"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+ * The server looks for its views and public folders next to the executable, link them next to the test binary.
+ */
+func TestMain(m *testing.M) {
+	ex, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+	for _, folder := range []string{"views", "public"} {
+		source, err := filepath.Abs(filepath.Join("..", folder))
+		if err != nil {
+			panic(err)
+		}
+		if err := os.Symlink(source, filepath.Join(filepath.Dir(ex), folder)); err != nil && !os.IsExist(err) {
+			panic(err)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+/**
+ * Write a self-signed certificate for 127.0.0.1 and its key in PEM files, return their paths and the certificate
+ */
+func selfSigned(t *testing.T) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "okuru test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, certificate
+}
+
+/**
+ * Create a password on the server, return the Strict-Transport-Security header and the link of the answer
+ */
+func createPassword(t *testing.T, client *http.Client, url string) (string, string) {
+	response, err := client.Post(url+"/api/v1", "application/json", strings.NewReader(`{"password": "hunter2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("create password status = %s", response.Status)
+	}
+	var p struct {
+		Link string `json:"link"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	return response.Header.Get("Strict-Transport-Security"), p.Link
+}
+
+/**
+ * Serve https with the self-signed certificate given to NewTLS: the links are https and HSTS is sent. The same
+ * server reached in plain http doesn't send HSTS, even when the client says it's https.
+ */
+func TestHttps(t *testing.T) {
+	certFile, keyFile, certificate := selfSigned(t)
+	config := utils.DefaultConfig()
+	config.Store = "memory"
+	config.TlsCert = certFile
+	config.TlsKey = keyFile
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, redirect, err := utils.NewTLS(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig == nil || redirect == nil || tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("NewTLS = %+v, %v", tlsConfig, redirect)
+	}
+
+	e, err := New(config, utils.Services{Store: store.NewMemory(), Blobs: blob.NewLocal(t.TempDir())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Services.Close()
+
+	server := httptest.NewUnstartedServer(e)
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	hsts, link := createPassword(t, client, server.URL)
+	if !strings.HasPrefix(hsts, "max-age=31536000") {
+		t.Errorf("Strict-Transport-Security = %q, want max-age=31536000", hsts)
+	}
+	if !strings.HasPrefix(link, server.URL+"/") {
+		t.Errorf("link = %q, want it on %s", link, server.URL)
+	}
+
+	plain := httptest.NewServer(e)
+	defer plain.Close()
+	request, err := http.NewRequest(http.MethodPost, plain.URL+"/api/v1", strings.NewReader(`{"password": "hunter2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Forwarded-Proto", "https")
+	response, err := plain.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if hsts := response.Header.Get("Strict-Transport-Security"); hsts != "" {
+		t.Errorf("Strict-Transport-Security = %q over http, want none", hsts)
+	}
+}
+
+/**
+ * Without TLS nor trusted proxies, HSTS is never sent and the links are https as behind a reverse proxy serving https
+ */
+func TestHttpsBehindProxy(t *testing.T) {
+	config := utils.DefaultConfig()
+	config.Store = "memory"
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(config, utils.Services{Store: store.NewMemory(), Blobs: blob.NewLocal(t.TempDir())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Services.Close()
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+	hsts, link := createPassword(t, server.Client(), server.URL)
+	if hsts != "" {
+		t.Errorf("Strict-Transport-Security = %q without TLS nor trusted proxies, want none", hsts)
+	}
+	if want := "https://" + strings.TrimPrefix(server.URL, "http://") + "/"; !strings.HasPrefix(link, want) {
+		t.Errorf("link = %q, want it on %s", link, want)
+	}
+}
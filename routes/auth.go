@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/eraffaelli/Okuru/controllers"
+	"github.com/labstack/echo"
+)
+
+func Auth(g *echo.Group) {
+	g.GET("/login", controllers.Login)
+	g.GET("/callback", controllers.LoginCallback)
+	g.GET("/logout", controllers.Logout)
+}
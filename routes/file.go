@@ -2,14 +2,35 @@ package routes
 
 import (
 	"github.com/eraffaelli/Okuru/controllers"
+	"github.com/eraffaelli/Okuru/middlewares"
+	. "github.com/eraffaelli/Okuru/utils"
 	"github.com/labstack/echo"
 )
 
-func File(g *echo.Group) {
-	g.GET("", controllers.IndexFile)
+func File(g *echo.Group, config *Config) {
+	g.GET("", controllers.IndexFile, middlewares.RequireLogin(config))
 	g.GET("/remove/:file_key", controllers.DeleteFile)
 	g.GET("/:file_key", controllers.ReadFile)
-	g.POST("/:file_key", controllers.DownloadFile)
-	g.POST("", controllers.AddFile)
+	g.POST("/:file_key", controllers.DownloadFile, middlewares.RevealLimit(config))
+	g.POST("", controllers.AddFile, middlewares.UploadLimit(config), middlewares.RequireLoginOrApiKey(config, true))
 	g.DELETE("/:file_key", controllers.DeleteFile)
 }
+
+func FileApi(g *echo.Group, config *Config) {
+	g.GET("", controllers.HelpFile)
+	g.HEAD("", controllers.HelpFile)
+	g.OPTIONS("", controllers.HelpFile)
+	g.POST("", controllers.CreateFile, middlewares.UploadLimit(config), middlewares.ApiKeyAuth(config, true))
+	g.GET("/:file_key", controllers.ReadFileApi)
+	g.POST("/:file_key/download", controllers.DownloadFileApi, middlewares.RevealLimit(config))
+	g.DELETE("/:file_key", controllers.DeleteFileApi)
+}
+
+func Upload(g *echo.Group, config *Config) {
+	g.OPTIONS("", controllers.OptionsUpload)
+	g.OPTIONS("/:upload_id", controllers.OptionsUpload)
+	g.POST("", controllers.CreateUpload, middlewares.UploadLimit(config), middlewares.ApiKeyAuth(config, true))
+	g.HEAD("/:upload_id", controllers.HeadUpload, middlewares.ApiKeyAuth(config, false))
+	g.PATCH("/:upload_id", controllers.PatchUpload, middlewares.ApiKeyAuth(config, false))
+	g.DELETE("/:upload_id", controllers.DeleteUpload, middlewares.ApiKeyAuth(config, false))
+}
@@ -2,13 +2,15 @@ package routes
 
 import (
 	"github.com/eraffaelli/Okuru/controllers"
+	"github.com/eraffaelli/Okuru/middlewares"
+	. "github.com/eraffaelli/Okuru/utils"
 	"github.com/labstack/echo"
 )
 
-func Index(e *echo.Echo) {
-	e.GET("/", controllers.Index)
-	e.POST("/", controllers.AddIndex)
+func Index(e *echo.Echo, config *Config) {
+	e.GET("/", controllers.Index, middlewares.RequireLogin(config))
+	e.POST("/", controllers.AddIndex, middlewares.CreateLimit(config), middlewares.RequireLoginOrApiKey(config, true))
 	e.GET("/:password_key", controllers.ReadIndex)
-	e.POST("/:password_key", controllers.RevealPassword)
+	e.POST("/:password_key", controllers.RevealPassword, middlewares.RevealLimit(config))
 	e.GET("/remove/:password_key", controllers.DeleteIndex)
 }
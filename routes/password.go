@@ -2,17 +2,20 @@ package routes
 
 import (
 	"github.com/eraffaelli/Okuru/controllers"
+	"github.com/eraffaelli/Okuru/middlewares"
+	. "github.com/eraffaelli/Okuru/utils"
 	"github.com/labstack/echo"
 )
 
-func Password(g *echo.Group) {
+func Password(g *echo.Group, config *Config) {
 	g.GET("", controllers.HelpPassword)
 	g.HEAD("", controllers.HelpPassword)
 	g.OPTIONS("", controllers.HelpPassword)
 	g.GET("/", controllers.HelpPassword)
 	g.HEAD("/", controllers.HelpPassword)
 	g.OPTIONS("/", controllers.HelpPassword)
-	g.GET("/:password_key", controllers.ReadPassword)
-	g.POST("", controllers.CreatePassword)
+	g.GET("/:password_key", controllers.ReadPassword, middlewares.RevealLimit(config))
+	g.POST("/:password_key", controllers.RevealPasswordApi, middlewares.RevealLimit(config))
+	g.POST("", controllers.CreatePassword, middlewares.CreateLimit(config), middlewares.ApiKeyAuth(config, true))
 	g.DELETE("/:password_key", controllers.DeletePassword)
 }
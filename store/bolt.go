@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"sync"
+	"time"
+)
+
+var boltBucket = []byte("okuru")
+
+/**
+ * Embedded store persisted in a single bbolt file, it doesn't need any external service.
+ * Expired entries are hidden right away and removed by the sweeper running in Watch.
+ */
+type Bolt struct {
+	db       *bolt.DB
+	interval time.Duration
+	done     chan struct{}
+	once     sync.Once
+}
+
+/**
+ * Open (or create) the database at path, expired entries are swept every interval.
+ * @param path
+ * @param interval
+ */
+func NewBolt(path string, interval time.Duration) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Bolt{db: db, interval: interval, done: make(chan struct{})}, nil
+}
+
+func (b *Bolt) load(tx *bolt.Tx, key string, now time.Time) (*entry, error) {
+	v := tx.Bucket(boltBucket).Get([]byte(key))
+	if v == nil {
+		return nil, ErrNotFound
+	}
+
+	e := new(entry)
+	if err := json.Unmarshal(v, e); err != nil {
+		return nil, err
+	}
+	if e.expired(now) {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+func (b *Bolt) save(tx *bolt.Tx, key string, e *entry) error {
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltBucket).Put([]byte(key), v)
+}
+
+func (b *Bolt) Put(key string, fields Fields, ttl int) error {
+	e := &entry{Fields: fields}
+	if ttl > 0 {
+		e.Expire = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return b.save(tx, key, e)
+	})
+}
+
+func (b *Bolt) Get(key string) (Fields, int, error) {
+	var fields Fields
+	var ttl int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		e, err := b.load(tx, key, now)
+		if err != nil {
+			return err
+		}
+		fields, ttl = e.Fields, e.ttl(now)
+		return nil
+	})
+	return fields, ttl, err
+}
+
+func (b *Bolt) Update(key string, fields Fields) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		e, err := b.load(tx, key, time.Now())
+		if err != nil {
+			return err
+		}
+		for k, v := range fields {
+			e.Fields[k] = v
+		}
+		return b.save(tx, key, e)
+	})
+}
+
+func (b *Bolt) ConsumeView(key string) (Fields, int, bool, error) {
+	var fields Fields
+	var ttl int
+	var last bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		e, err := b.load(tx, key, now)
+		if err != nil {
+			return err
+		}
+
+		fields, ttl = e.Fields, e.ttl(now)
+		last = countView(e.Fields)
+		if last {
+			return tx.Bucket(boltBucket).Delete([]byte(key))
+		}
+		return b.save(tx, key, e)
+	})
+	return fields, ttl, last, err
+}
+
+func (b *Bolt) Increment(key string, field string) (int, error) {
+	var value int
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		e, err := b.load(tx, key, time.Now())
+		if err != nil {
+			return err
+		}
+		value = increment(e.Fields, field)
+		return b.save(tx, key, e)
+	})
+	return value, err
+}
+
+func (b *Bolt) Delete(key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+/**
+ * Sweep the expired entries every interval until ctx is done or the store is closed, fn is called for each of them.
+ * Entries that expired while Okuru was stopped are swept on the first run.
+ */
+func (b *Bolt) Watch(ctx context.Context, fn func(key string)) error {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		expired, err := b.sweep()
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			fn(key)
+		}
+
+		select {
+		case <-b.done:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Bolt) sweep() ([]string, error) {
+	var expired []string
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		now := time.Now()
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			e := new(entry)
+			if err := json.Unmarshal(v, e); err != nil {
+				log.Error("Bolt sweep err unmarshal ", string(k), " : ", err)
+				continue
+			}
+			if e.expired(now) {
+				expired = append(expired, string(k))
+			}
+		}
+
+		// Deleting while iterating with a cursor skips keys, so it's done afterward
+		for _, key := range expired {
+			if err := bucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+func (b *Bolt) Ping() error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+func (b *Bolt) Close() error {
+	b.once.Do(func() {
+		close(b.done)
+	})
+	return b.db.Close()
+}
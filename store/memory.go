@@ -0,0 +1,173 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/**
+ * In-process store, everything is lost on restart. Expired entries are swept every second.
+ */
+type Memory struct {
+	mu       sync.Mutex
+	entries  map[string]*entry
+	watchers map[int]func(key string)
+	watching int
+	done     chan struct{}
+	once     sync.Once
+}
+
+func NewMemory() *Memory {
+	m := &Memory{
+		entries:  make(map[string]*entry),
+		watchers: make(map[int]func(key string)),
+		done:     make(chan struct{}),
+	}
+	go m.sweep()
+	return m
+}
+
+func (m *Memory) Put(key string, fields Fields, ttl int) error {
+	e := &entry{Fields: fields.copy()}
+	if ttl > 0 {
+		e.Expire = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = e
+	return nil
+}
+
+// lookup must be called with the lock held
+func (m *Memory) lookup(key string, now time.Time) (*entry, error) {
+	e, ok := m.entries[key]
+	if !ok || e.expired(now) {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+func (m *Memory) Get(key string) (Fields, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	e, err := m.lookup(key, now)
+	if err != nil {
+		return nil, 0, err
+	}
+	return e.Fields.copy(), e.ttl(now), nil
+}
+
+func (m *Memory) Update(key string, fields Fields) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.lookup(key, time.Now())
+	if err != nil {
+		return err
+	}
+	for k, v := range fields {
+		e.Fields[k] = v
+	}
+	return nil
+}
+
+func (m *Memory) ConsumeView(key string) (Fields, int, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	e, err := m.lookup(key, now)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	last := countView(e.Fields)
+	if last {
+		delete(m.entries, key)
+	}
+	return e.Fields.copy(), e.ttl(now), last, nil
+}
+
+func (m *Memory) Increment(key string, field string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.lookup(key, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return increment(e.Fields, field), nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *Memory) Watch(ctx context.Context, fn func(key string)) error {
+	m.mu.Lock()
+	m.watching++
+	id := m.watching
+	m.watchers[id] = fn
+	m.mu.Unlock()
+
+	select {
+	case <-m.done:
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	delete(m.watchers, id)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) sweep() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			var expired []string
+			m.mu.Lock()
+			for key, e := range m.entries {
+				if e.expired(now) {
+					delete(m.entries, key)
+					expired = append(expired, key)
+				}
+			}
+			watchers := make([]func(key string), 0, len(m.watchers))
+			for _, fn := range m.watchers {
+				watchers = append(watchers, fn)
+			}
+			m.mu.Unlock()
+
+			for _, key := range expired {
+				for _, fn := range watchers {
+					fn(key)
+				}
+			}
+		}
+	}
+}
+
+func (m *Memory) Ping() error {
+	return nil
+}
+
+func (m *Memory) Close() error {
+	m.once.Do(func() {
+		close(m.done)
+	})
+	return nil
+}
@@ -0,0 +1,267 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Count a view and remove the key on the last one in a single step, so two concurrent reads of a one view
+ * secret can't both succeed. Returns nil if the key doesn't exist, otherwise the hash before the view, the ttl,
+ * the new views count and 1 if it was the last view.
+ */
+var consumeViewScript = redis.NewScript(1, `
+local fields = redis.call('HGETALL', KEYS[1])
+if #fields == 0 then
+	return nil
+end
+local ttl = redis.call('TTL', KEYS[1])
+local views, count = 0, 0
+for i = 1, #fields, 2 do
+	if fields[i] == 'views' then
+		views = tonumber(fields[i + 1]) or 0
+	elseif fields[i] == 'views_count' then
+		count = tonumber(fields[i + 1]) or 0
+	end
+end
+count = count + 1
+local last = 0
+if count >= views then
+	redis.call('DEL', KEYS[1])
+	last = 1
+else
+	redis.call('HSET', KEYS[1], 'views_count', count)
+end
+return {fields, ttl, count, last}
+`)
+
+// Set fields only if the key still exists, otherwise HSET would create it without expiration
+var updateScript = redis.NewScript(1, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HMSET', KEYS[1], unpack(ARGV))
+return 1
+`)
+
+// Same as updateScript, HINCRBY would create a key without expiration
+var incrementScript = redis.NewScript(1, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return nil
+end
+return redis.call('HINCRBY', KEYS[1], ARGV[1], 1)
+`)
+
+const watchHealthCheck = time.Minute
+
+/**
+ * Redis store, every entry is a hash, expiration relies on the keyspace notifications (notify-keyspace-events KEA)
+ */
+type Redis struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+func NewRedis(pool *redis.Pool, prefix string) *Redis {
+	return &Redis{pool: pool, prefix: prefix}
+}
+
+func (r *Redis) Put(key string, fields Fields, ttl int) error {
+	c := r.pool.Get()
+	defer c.Close()
+
+	c.Send("MULTI")
+	c.Send("HMSET", redis.Args{r.prefix + key}.AddFlat(map[string]string(fields))...)
+	if ttl > 0 {
+		c.Send("EXPIRE", r.prefix+key, ttl)
+	}
+	_, err := c.Do("EXEC")
+	return err
+}
+
+func (r *Redis) Get(key string) (Fields, int, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	return r.get(c, key)
+}
+
+func (r *Redis) get(c redis.Conn, key string) (Fields, int, error) {
+	c.Send("MULTI")
+	c.Send("HGETALL", r.prefix+key)
+	c.Send("TTL", r.prefix+key)
+	reply, err := redis.Values(c.Do("EXEC"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fields, err := redis.StringMap(reply[0], nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl, err := redis.Int(reply[1], nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(fields) == 0 || ttl == -2 {
+		return nil, 0, ErrNotFound
+	}
+	return Fields(fields), ttl, nil
+}
+
+func (r *Redis) Update(key string, fields Fields) error {
+	c := r.pool.Get()
+	defer c.Close()
+
+	updated, err := redis.Bool(updateScript.Do(c, redis.Args{r.prefix + key}.AddFlat(map[string]string(fields))...))
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Redis) ConsumeView(key string) (Fields, int, bool, error) {
+	c := r.pool.Get()
+	defer c.Close()
+
+	reply, err := redis.Values(consumeViewScript.Do(c, r.prefix+key))
+	if err == redis.ErrNil {
+		return nil, 0, false, ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	if len(reply) != 4 {
+		return nil, 0, false, errors.New("unexpected reply from the consume view script")
+	}
+	fields, err := redis.StringMap(reply[0], nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	ttl, err := redis.Int(reply[1], nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	count, err := redis.Int(reply[2], nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	last, err := redis.Bool(reply[3], nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	fields["views_count"] = strconv.Itoa(count)
+	return Fields(fields), ttl, last, nil
+}
+
+func (r *Redis) Increment(key string, field string) (int, error) {
+	c := r.pool.Get()
+	defer c.Close()
+
+	value, err := redis.Int(incrementScript.Do(c, r.prefix+key, field))
+	if err == redis.ErrNil {
+		return 0, ErrNotFound
+	}
+	return value, err
+}
+
+func (r *Redis) Delete(key string) error {
+	c := r.pool.Get()
+	defer c.Close()
+
+	_, err := c.Do("DEL", r.prefix+key)
+	return err
+}
+
+func (r *Redis) Watch(ctx context.Context, fn func(key string)) error {
+	c := r.pool.Get()
+	defer c.Close()
+
+	psc := redis.PubSubConn{Conn: c}
+	if err := psc.PSubscribe("__keyevent@*__:expired"); err != nil {
+		return err
+	}
+
+	// Ping regularly so a dead connection is detected by the receive timeout instead of blocking forever.
+	// The subscription is ended when ctx is done, this goroutine is the only one writing on the connection.
+	// It's waited for before the connection is closed.
+	done := make(chan struct{})
+	pinging := make(chan struct{})
+	defer func() {
+		close(done)
+		<-pinging
+	}()
+	go func() {
+		defer close(pinging)
+		ticker := time.NewTicker(watchHealthCheck)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				psc.PUnsubscribe()
+				return
+			case <-ticker.C:
+				if err := psc.Ping(""); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		switch v := psc.ReceiveWithTimeout(watchHealthCheck + 10*time.Second).(type) {
+		case redis.Message:
+			r.expired(string(v.Data), fn)
+		case redis.PMessage:
+			r.expired(string(v.Data), fn)
+		case redis.Subscription:
+			log.Debug("Message from redis subscription ok : ", v.Kind, " ", v.Channel)
+			if v.Count == 0 {
+				return nil
+			}
+		case error:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return v
+		}
+	}
+}
+
+func (r *Redis) expired(key string, fn func(key string)) {
+	log.Debug("Expired key from redis : ", key)
+	if !strings.HasPrefix(key, r.prefix) {
+		return
+	}
+	fn(strings.TrimPrefix(key, r.prefix))
+}
+
+/**
+ * Connections of the store, other users of the same redis share them instead of opening their own
+ */
+func (r *Redis) Pool() *redis.Pool {
+	return r.pool
+}
+
+func (r *Redis) Ping() error {
+	c := r.pool.Get()
+	defer c.Close()
+
+	_, err := c.Do("PING")
+	return err
+}
+
+func (r *Redis) Close() error {
+	return r.pool.Close()
+}
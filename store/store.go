@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"math"
+	"strconv"
+	"time"
+)
+
+var ErrNotFound = errors.New("key not found")
+
+/**
+ * Fields of a stored entry, named after the redis tags of the models so they can be scanned into them.
+ */
+type Fields map[string]string
+
+/**
+ * Store keeps the passwords and files metadata for a limited time.
+ */
+type Store interface {
+	// Put saves the fields under key for ttl seconds, the key never expires if ttl <= 0
+	Put(key string, fields Fields, ttl int) error
+	// Get returns the fields of key and its remaining time to live in seconds (-1 if it never expires)
+	Get(key string) (Fields, int, error)
+	// Update sets some fields of an existing key without changing its time to live
+	Update(key string, fields Fields) error
+	// ConsumeView counts one view of key, the key is removed once views_count reaches views.
+	// It returns the fields with the new views_count, the remaining ttl and true if it was the last view.
+	ConsumeView(key string) (Fields, int, bool, error)
+	// Increment adds one to a numeric field of an existing key without changing its time to live, it returns the new value
+	Increment(key string, field string) (int, error)
+	// Delete removes key, it's not an error if the key doesn't exist
+	Delete(key string) error
+	// Watch calls fn with every key that expires, it blocks until ctx is done, the store is closed or an error occurs
+	Watch(ctx context.Context, fn func(key string)) error
+	Ping() error
+	Close() error
+}
+
+/**
+ * Scan the fields into dest, a pointer to a struct with redis tags.
+ */
+func (f Fields) Scan(dest interface{}) error {
+	src := make([]interface{}, 0, len(f)*2)
+	for k, v := range f {
+		src = append(src, []byte(k), []byte(v))
+	}
+	return redis.ScanStruct(src, dest)
+}
+
+func (f Fields) copy() Fields {
+	c := make(Fields, len(f))
+	for k, v := range f {
+		c[k] = v
+	}
+	return c
+}
+
+/**
+ * Entry as kept by the stores that handle the expiration themselves.
+ */
+type entry struct {
+	Fields Fields    `json:"fields"`
+	Expire time.Time `json:"expire"`
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.Expire.IsZero() && !now.Before(e.Expire)
+}
+
+func (e *entry) ttl(now time.Time) int {
+	if e.Expire.IsZero() {
+		return -1
+	}
+	return int(math.Ceil(e.Expire.Sub(now).Seconds()))
+}
+
+/**
+ * Increment views_count and return true if it reached views, meaning the entry must be removed.
+ */
+func countView(f Fields) bool {
+	views, _ := strconv.Atoi(f["views"])
+	vc, _ := strconv.Atoi(f["views_count"])
+	vc++
+	f["views_count"] = strconv.Itoa(vc)
+	return vc >= views
+}
+
+func increment(f Fields, field string) int {
+	value, _ := strconv.Atoi(f[field])
+	value++
+	f[field] = strconv.Itoa(value)
+	return value
+}
@@ -0,0 +1,315 @@
+package store
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/garyburd/redigo/redis"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+ * Every store must pass the same contract. expire ends the time to live of a key right away, as if it had run out,
+ * and for redis sends the notification the server sends then, miniredis doesn't have the keyspace notifications.
+ */
+type testStore struct {
+	name string
+	open func(t *testing.T) (s Store, expire func(key string))
+}
+
+var testStores = []testStore{
+	{"memory", openMemory},
+	{"bolt", openBolt},
+	{"redis", openRedis},
+}
+
+func openMemory(t *testing.T) (Store, func(key string)) {
+	m := NewMemory()
+	t.Cleanup(func() { m.Close() })
+	return m, func(key string) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if e, ok := m.entries[key]; ok {
+			e.Expire = time.Now()
+		}
+	}
+}
+
+func openBolt(t *testing.T) (Store, func(key string)) {
+	b, err := NewBolt(filepath.Join(t.TempDir(), "okuru.db"), 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b, func(key string) {
+		err := b.db.Update(func(tx *bolt.Tx) error {
+			e, err := b.load(tx, key, time.Time{})
+			if err != nil {
+				return err
+			}
+			e.Expire = time.Now()
+			return b.save(tx, key, e)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func openRedis(t *testing.T) (Store, func(key string)) {
+	mr := miniredis.RunT(t)
+	r := NewRedis(testPool(mr), "okuru_")
+	t.Cleanup(func() { r.Close() })
+	return r, func(key string) {
+		mr.SetTTL("okuru_"+key, time.Second)
+		mr.FastForward(time.Second)
+		mr.Publish("__keyevent@0__:expired", "okuru_"+key)
+	}
+}
+
+func testPool(mr *miniredis.Miniredis) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle: 10,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", mr.Addr())
+		},
+	}
+}
+
+func forEachStore(t *testing.T, test func(t *testing.T, s Store, expire func(key string))) {
+	for _, ts := range testStores {
+		t.Run(ts.name, func(t *testing.T) {
+			s, expire := ts.open(t)
+			test(t, s, expire)
+		})
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, expire func(key string)) {
+		if err := s.Put("a", Fields{"token": "x", "views": "2"}, 60); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put("b", Fields{"token": "y"}, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		fields, ttl, err := s.Get("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fields["token"] != "x" || fields["views"] != "2" {
+			t.Errorf("Get(a) = %v", fields)
+		}
+		if ttl < 59 || ttl > 60 {
+			t.Errorf("Get(a) ttl = %d, want 60", ttl)
+		}
+
+		_, ttl, err = s.Get("b")
+		if err != nil || ttl != -1 {
+			t.Errorf("Get(b) ttl = %d, err = %v, want -1 and no error", ttl, err)
+		}
+
+		if _, _, err := s.Get("missing"); err != ErrNotFound {
+			t.Errorf("Get(missing) err = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, expire func(key string)) {
+		if err := s.Put("a", Fields{"token": "x"}, 60); err != nil {
+			t.Fatal(err)
+		}
+		expire("a")
+
+		if _, _, err := s.Get("a"); err != ErrNotFound {
+			t.Errorf("Get err = %v, want ErrNotFound", err)
+		}
+		if _, _, _, err := s.ConsumeView("a"); err != ErrNotFound {
+			t.Errorf("ConsumeView err = %v, want ErrNotFound", err)
+		}
+		if err := s.Update("a", Fields{"token": "y"}); err != ErrNotFound {
+			t.Errorf("Update err = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestConsumeView(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, expire func(key string)) {
+		if err := s.Put("a", Fields{"token": "x", "views": "2", "views_count": "0"}, 60); err != nil {
+			t.Fatal(err)
+		}
+
+		fields, ttl, last, err := s.ConsumeView("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last || fields["views_count"] != "1" || fields["token"] != "x" || ttl < 59 {
+			t.Errorf("first view = %v, %d, %v", fields, ttl, last)
+		}
+
+		fields, _, last, err = s.ConsumeView("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !last || fields["views_count"] != "2" || fields["token"] != "x" {
+			t.Errorf("last view = %v, %v", fields, last)
+		}
+
+		if _, _, err := s.Get("a"); err != ErrNotFound {
+			t.Errorf("Get after the last view err = %v, want ErrNotFound", err)
+		}
+		if _, _, _, err := s.ConsumeView("a"); err != ErrNotFound {
+			t.Errorf("ConsumeView after the last view err = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestUpdateIncrement(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, expire func(key string)) {
+		if err := s.Update("missing", Fields{"a": "b"}); err != ErrNotFound {
+			t.Errorf("Update(missing) err = %v, want ErrNotFound", err)
+		}
+		if _, err := s.Increment("missing", "sent"); err != ErrNotFound {
+			t.Errorf("Increment(missing) err = %v, want ErrNotFound", err)
+		}
+		// Neither must create the key
+		if _, _, err := s.Get("missing"); err != ErrNotFound {
+			t.Errorf("Get(missing) err = %v, want ErrNotFound", err)
+		}
+
+		if err := s.Put("a", Fields{"token": "x", "sent": "0"}, 60); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Update("a", Fields{"offset": "10"}); err != nil {
+			t.Fatal(err)
+		}
+		for want := 1; want <= 2; want++ {
+			value, err := s.Increment("a", "sent")
+			if err != nil || value != want {
+				t.Errorf("Increment = %d, %v, want %d", value, err, want)
+			}
+		}
+
+		fields, ttl, err := s.Get("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fields["token"] != "x" || fields["offset"] != "10" || fields["sent"] != "2" {
+			t.Errorf("Get = %v", fields)
+		}
+		if ttl < 59 {
+			t.Errorf("ttl = %d, Update and Increment must keep it", ttl)
+		}
+	})
+}
+
+func TestDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, expire func(key string)) {
+		if err := s.Put("a", Fields{"token": "x"}, 60); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Delete("a"); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.Get("a"); err != ErrNotFound {
+			t.Errorf("Get err = %v, want ErrNotFound", err)
+		}
+		if err := s.Delete("a"); err != nil {
+			t.Errorf("Delete of a missing key err = %v", err)
+		}
+	})
+}
+
+func TestWatch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, expire func(key string)) {
+		if err := s.Put("a", Fields{"token": "x"}, 60); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put("b", Fields{"token": "y"}, 60); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		keys := make(chan string, 10)
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- s.Watch(ctx, func(key string) {
+				keys <- key
+			})
+		}()
+		// Let the redis subscription start, a notification sent before it is lost
+		time.Sleep(100 * time.Millisecond)
+
+		expire("a")
+		select {
+		case key := <-keys:
+			if key != "a" {
+				t.Errorf("Watch got %q, want a", key)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("Watch didn't get the expired key")
+		}
+
+		cancel()
+		select {
+		case err := <-stopped:
+			if err != nil {
+				t.Errorf("Watch err = %v", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("Watch didn't stop with its context")
+		}
+
+		select {
+		case key := <-keys:
+			t.Errorf("Watch got %q, only a expired", key)
+		default:
+		}
+		if _, _, err := s.Get("b"); err != nil {
+			t.Errorf("Get(b) err = %v", err)
+		}
+	})
+}
+
+/**
+ * A one view share read by many clients at once must be given to exactly one of them.
+ */
+func TestConsumeViewRace(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, expire func(key string)) {
+		const readers = 50
+		for round := 0; round < 20; round++ {
+			if err := s.Put("a", Fields{"token": "x", "views": "1", "views_count": "0"}, 60); err != nil {
+				t.Fatal(err)
+			}
+
+			start := make(chan struct{})
+			results := make(chan error, readers)
+			for i := 0; i < readers; i++ {
+				go func() {
+					<-start
+					_, _, _, err := s.ConsumeView("a")
+					results <- err
+				}()
+			}
+			close(start)
+
+			succeeded := 0
+			for i := 0; i < readers; i++ {
+				switch err := <-results; err {
+				case nil:
+					succeeded++
+				case ErrNotFound:
+				default:
+					t.Fatalf("ConsumeView err = %v", err)
+				}
+			}
+			if succeeded != 1 {
+				t.Fatalf("round %d: %d readers got the share, want exactly 1", round, succeeded)
+			}
+		}
+	})
+}
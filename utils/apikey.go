@@ -0,0 +1,157 @@
+package utils
+
+import (
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/eraffaelli/Okuru/models"
+	"github.com/eraffaelli/Okuru/store"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prefix of the minted keys, so they are easy to recognize in a config or a leaked file
+const apiKeyPrefix = "okuru_"
+
+/**
+ * Id of an API key, the hex sha256 of the key. Only the id is stored, the key itself is only known by its owner.
+ */
+func ApiKeyId(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+/**
+ * Id of an API key given either by the key itself or already by its id
+ */
+func ApiKeyIdOf(keyOrId string) string {
+	if strings.HasPrefix(keyOrId, apiKeyPrefix) {
+		return ApiKeyId(keyOrId)
+	}
+	return keyOrId
+}
+
+/**
+ * Create an API key, the key is returned only once and can't be found again.
+ * @param {string} label to recognize the key, for example its owner
+ * @param {number} quota number of shares it can create per day, 0 for unlimited
+ */
+func NewApiKey(config *Config, label string, quota int) (string, *models.ApiKey, error) {
+	raw := make([]byte, 32)
+	if _, err := cryptorand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	k := &models.ApiKey{
+		Id:        ApiKeyId(key),
+		Label:     label,
+		Quota:     quota,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	err := config.Services.Store.Put("apikey_"+k.Id, store.Fields{
+		"label":      k.Label,
+		"quota":      strconv.Itoa(k.Quota),
+		"used":       "0",
+		"created_at": k.CreatedAt,
+	}, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, k, nil
+}
+
+/**
+ * Get an API key from its id, store.ErrNotFound if it doesn't exist or was revoked
+ */
+func GetApiKey(config *Config, id string) (*models.ApiKey, error) {
+	fields, _, err := config.Services.Store.Get("apikey_" + id)
+	if err != nil {
+		return nil, err
+	}
+	k := new(models.ApiKey)
+	if err := fields.Scan(k); err != nil {
+		return nil, err
+	}
+	k.Id = id
+	return k, nil
+}
+
+/**
+ * Revoke an API key, given by the key itself or by its id
+ */
+func RevokeApiKey(config *Config, keyOrId string) (*models.ApiKey, error) {
+	id := ApiKeyIdOf(keyOrId)
+	k, err := GetApiKey(config, id)
+	if err != nil {
+		return nil, err
+	}
+	return k, config.Services.Store.Delete("apikey_" + id)
+}
+
+/**
+ * Check the API key sent with a request. With count, the key must have shares left on the quota of the day,
+ * CountApiKey counts the share once it's created.
+ */
+func UseApiKey(config *Config, key string, count bool) (*models.ApiKey, *echo.HTTPError) {
+	if key == "" {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "An API key is needed to create a share")
+	}
+
+	k, err := GetApiKey(config, ApiKeyId(key))
+	if err == store.ErrNotFound {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid API key")
+	}
+	if err != nil {
+		log.Errorf("UseApiKey() Store err get : %+v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	if count && k.Quota > 0 && UsedToday(k) >= k.Quota {
+		return nil, echo.NewHTTPError(http.StatusTooManyRequests, "Quota of the API key exceeded ("+strconv.Itoa(k.Quota)+" shares per day)")
+	}
+	return k, nil
+}
+
+/**
+ * Count a share created with the key on the quota of the day. Concurrent requests are all checked by UseApiKey
+ * before any is counted, a few more shares than the quota are acceptable.
+ */
+func CountApiKey(config *Config, k *models.ApiKey) {
+	if k.Quota <= 0 {
+		return
+	}
+
+	// Two requests starting the day at the same time can both reset the counter, a few more shares are acceptable
+	if today := apiKeyDay(); k.Day != today {
+		if err := config.Services.Store.Update("apikey_"+k.Id, store.Fields{"day": today, "used": "0"}); err != nil {
+			log.Errorf("CountApiKey() Store err update : %+v", err)
+			return
+		}
+	}
+	used, err := config.Services.Store.Increment("apikey_"+k.Id, "used")
+	if err != nil {
+		log.Errorf("CountApiKey() Store err increment : %+v", err)
+		return
+	}
+	k.Used = used
+}
+
+/**
+ * Shares created with the key today, its counter is from a previous day when the key wasn't used yet today
+ */
+func UsedToday(k *models.ApiKey) int {
+	if k.Day != apiKeyDay() {
+		return 0
+	}
+	return k.Used
+}
+
+// Day of the quotas, in UTC
+func apiKeyDay() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
@@ -0,0 +1,490 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Settings of Okuru. Each one is read, from the lowest to the highest precedence, from its default, the yaml file
+ * given with --config or OKURU_CONFIG (key of the yaml tag), its environment variable (env tag) and its flag
+ * (the yaml key with dashes, e.g. --max-file-size). Seconds are numbers, lists are comma separated outside of the file.
+ */
+type Config struct {
+	Debug           bool   `yaml:"debug" env:"OKURU_DEBUG" help:"log the debug messages"`
+	AppPort         int    `yaml:"app_port" env:"OKURU_APP_PORT" help:"port the server listens on"`
+	ShutdownTimeout int    `yaml:"shutdown_timeout" env:"OKURU_SHUTDOWN_TIMEOUT" help:"seconds given to the requests in progress and the webhook deliveries to finish on shutdown"`
+	NoSsl           bool   `yaml:"no_ssl" env:"NO_SSL" help:"not using https: http links without base_url nor trusted_proxies, login cookies sent over http too"`
+	BaseUrl         string `yaml:"base_url" env:"OKURU_BASE_URL" help:"public url of Okuru (e.g. https://example.com/okuru), the links are made from it and the routes are served under its path"`
+	TokenSeparator  string `yaml:"token_separator" env:"OKURU_TOKEN_SEPARATOR" help:"separator of the keys in the links"`
+	AppName         string `yaml:"app_name" env:"OKURU_APP_NAME" help:"name of the application"`
+	Logo            string `yaml:"logo" env:"OKURU_LOGO" help:"logo, path from the public/image folder"`
+	Disclaimer      string `yaml:"disclaimer" env:"OKURU_DISCLAIMER" help:"disclaimer shown on the pages, \\n for a line break"`
+	Copyright       string `yaml:"copyright" env:"OKURU_COPYRIGHT" help:"copyright shown on the pages"`
+
+	TlsCert       string   `yaml:"tls_cert" env:"OKURU_TLS_CERT" help:"certificate (PEM) to serve https on app_port, with tls_key"`
+	TlsKey        string   `yaml:"tls_key" env:"OKURU_TLS_KEY" help:"private key (PEM) of tls_cert"`
+	AcmeDomains   []string `yaml:"acme_domains" env:"OKURU_ACME_DOMAINS" help:"domains to get a certificate for with ACME (Let's Encrypt) and serve https on app_port"`
+	AcmeEmail     string   `yaml:"acme_email" env:"OKURU_ACME_EMAIL" help:"contact email of the ACME account"`
+	AcmeDirectory string   `yaml:"acme_directory" env:"OKURU_ACME_DIRECTORY" help:"ACME directory url, defaults to Let's Encrypt"`
+	AcmeCaCert    string   `yaml:"acme_ca_cert" env:"OKURU_ACME_CA_CERT" help:"CA certificate of the ACME directory, e.g. for a Pebble test server"`
+	AcmeCacheDir  string   `yaml:"acme_cache_dir" env:"OKURU_ACME_CACHE_DIR" help:"folder keeping the ACME account and certificates"`
+	HttpPort      int      `yaml:"http_port" env:"OKURU_HTTP_PORT" help:"port of the http listener redirecting to https and answering the ACME challenges, 0 for none"`
+	HstsMaxAge    int      `yaml:"hsts_max_age" env:"OKURU_HSTS_MAX_AGE" help:"seconds the browsers must only use https (Strict-Transport-Security), 0 to disable"`
+
+	Store                 string   `yaml:"store" env:"OKURU_STORE" help:"store of the shares: redis, bolt or memory"`
+	BoltPath              string   `yaml:"bolt_path" env:"OKURU_BOLT_PATH" help:"database file of the bolt store"`
+	BoltSweepInterval     int      `yaml:"bolt_sweep_interval" env:"OKURU_BOLT_SWEEP_INTERVAL" help:"seconds between two removals of the expired shares of the bolt store"`
+	RedisUrl              string   `yaml:"redis_url" env:"REDIS_URL" secret:"true" help:"redis url, takes precedence over the other redis connection settings"`
+	RedisHost             string   `yaml:"redis_host" env:"REDIS_HOST" help:"redis host"`
+	RedisPort             int      `yaml:"redis_port" env:"REDIS_PORT" help:"redis port"`
+	RedisUsername         string   `yaml:"redis_username" env:"REDIS_USERNAME" help:"redis ACL user"`
+	RedisPassword         string   `yaml:"redis_password" env:"REDIS_PASSWORD" secret:"true" help:"redis password"`
+	RedisDb               int      `yaml:"redis_db" env:"REDIS_DB" help:"redis database"`
+	RedisPrefix           string   `yaml:"redis_prefix" env:"REDIS_PREFIX" help:"prefix of the redis keys"`
+	RedisTls              bool     `yaml:"redis_tls" env:"REDIS_TLS" help:"connect to redis with TLS"`
+	RedisTlsSkipVerify    bool     `yaml:"redis_tls_skip_verify" env:"REDIS_TLS_SKIP_VERIFY" help:"don't check the certificate of redis"`
+	RedisTlsCaCert        string   `yaml:"redis_tls_ca_cert" env:"REDIS_TLS_CA_CERT" help:"CA certificate of redis"`
+	RedisSentinelAddrs    []string `yaml:"redis_sentinel_addrs" env:"REDIS_SENTINEL_ADDRS" help:"sentinels (host:port) to ask the redis master to"`
+	RedisSentinelMaster   string   `yaml:"redis_sentinel_master" env:"REDIS_SENTINEL_MASTER" help:"name of the master monitored by the sentinels"`
+	RedisSentinelPassword string   `yaml:"redis_sentinel_password" env:"REDIS_SENTINEL_PASSWORD" secret:"true" help:"password of the sentinels"`
+	RedisMaxIdle          int      `yaml:"redis_max_idle" env:"REDIS_MAX_IDLE" help:"idle connections kept in the redis pool"`
+	RedisMaxActive        int      `yaml:"redis_max_active" env:"REDIS_MAX_ACTIVE" help:"max connections of the redis pool, 0 for unlimited"`
+	RedisIdleTimeout      int      `yaml:"redis_idle_timeout" env:"REDIS_IDLE_TIMEOUT" help:"seconds before an idle redis connection is closed"`
+	RedisTimeout          int      `yaml:"redis_timeout" env:"REDIS_TIMEOUT" help:"seconds to connect, read or write to redis"`
+
+	FileFolder       string `yaml:"file_folder" env:"OKURU_FILE_FOLDER" help:"folder of the files with the local blob storage"`
+	UploadFolder     string `yaml:"upload_folder" env:"OKURU_UPLOAD_FOLDER" help:"folder of the resumable uploads, defaults to the uploads folder of file_folder"`
+	UploadExpiration int    `yaml:"upload_expiration" env:"OKURU_UPLOAD_EXPIRATION" help:"seconds an unfinished upload is kept"`
+	MaxFileSize      int    `yaml:"max_file_size" env:"OKURU_MAX_FILE_SIZE" help:"max size of an upload, in megabytes"`
+	BlobStorage      string `yaml:"blob_storage" env:"OKURU_BLOB_STORAGE" help:"storage of the files: local or s3"`
+	S3Endpoint       string `yaml:"s3_endpoint" env:"OKURU_S3_ENDPOINT" help:"s3 endpoint (host:port)"`
+	S3Bucket         string `yaml:"s3_bucket" env:"OKURU_S3_BUCKET" help:"s3 bucket"`
+	S3AccessKey      string `yaml:"s3_access_key" env:"OKURU_S3_ACCESS_KEY" help:"s3 access key"`
+	S3SecretKey      string `yaml:"s3_secret_key" env:"OKURU_S3_SECRET_KEY" secret:"true" help:"s3 secret key"`
+	S3Region         string `yaml:"s3_region" env:"OKURU_S3_REGION" help:"s3 region"`
+	S3UseSsl         bool   `yaml:"s3_use_ssl" env:"OKURU_S3_USE_SSL" help:"connect to s3 with https"`
+	S3PathStyle      bool   `yaml:"s3_path_style" env:"OKURU_S3_PATH_STYLE" help:"path style s3 urls"`
+
+	PasswordTtlMin       int `yaml:"password_ttl_min" env:"OKURU_PASSWORD_TTL_MIN" help:"min seconds before a password expires"`
+	PasswordTtlMax       int `yaml:"password_ttl_max" env:"OKURU_PASSWORD_TTL_MAX" help:"max seconds before a password expires"`
+	PasswordTtlDefault   int `yaml:"password_ttl_default" env:"OKURU_PASSWORD_TTL_DEFAULT" help:"seconds before a password expires when none is given"`
+	PasswordViewsMin     int `yaml:"password_views_min" env:"OKURU_PASSWORD_VIEWS_MIN" help:"min views of a password"`
+	PasswordViewsMax     int `yaml:"password_views_max" env:"OKURU_PASSWORD_VIEWS_MAX" help:"max views of a password"`
+	PasswordViewsDefault int `yaml:"password_views_default" env:"OKURU_PASSWORD_VIEWS_DEFAULT" help:"views of a password when none is given"`
+	FileTtlMin           int `yaml:"file_ttl_min" env:"OKURU_FILE_TTL_MIN" help:"min seconds before a file expires"`
+	FileTtlMax           int `yaml:"file_ttl_max" env:"OKURU_FILE_TTL_MAX" help:"max seconds before a file expires"`
+	FileTtlDefault       int `yaml:"file_ttl_default" env:"OKURU_FILE_TTL_DEFAULT" help:"seconds before a file expires when none is given"`
+	FileViewsMin         int `yaml:"file_views_min" env:"OKURU_FILE_VIEWS_MIN" help:"min downloads of a file"`
+	FileViewsMax         int `yaml:"file_views_max" env:"OKURU_FILE_VIEWS_MAX" help:"max downloads of a file"`
+	FileViewsDefault     int `yaml:"file_views_default" env:"OKURU_FILE_VIEWS_DEFAULT" help:"downloads of a file when none is given"`
+
+	MaxFailedAttempts      int      `yaml:"max_failed_attempts" env:"OKURU_MAX_FAILED_ATTEMPTS" help:"default wrong passphrases or passwords before a share is destroyed"`
+	ApiKeys                bool     `yaml:"api_keys" env:"OKURU_API_KEYS" help:"require an API key to create a share"`
+	OidcIssuer             string   `yaml:"oidc_issuer" env:"OKURU_OIDC_ISSUER" help:"OpenID Connect provider, enables the login of the creation pages"`
+	OidcClientId           string   `yaml:"oidc_client_id" env:"OKURU_OIDC_CLIENT_ID" help:"OpenID Connect client id"`
+	OidcClientSecret       string   `yaml:"oidc_client_secret" env:"OKURU_OIDC_CLIENT_SECRET" secret:"true" help:"OpenID Connect client secret"`
+	OidcRedirectUrl        string   `yaml:"oidc_redirect_url" env:"OKURU_OIDC_REDIRECT_URL" help:"callback url registered on the provider"`
+	OidcScopes             []string `yaml:"oidc_scopes" env:"OKURU_OIDC_SCOPES" help:"OpenID Connect scopes"`
+	SessionSecret          string   `yaml:"session_secret" env:"OKURU_SESSION_SECRET" secret:"true" help:"secret signing the session cookies, random on each start when not set"`
+	SessionDuration        int      `yaml:"session_duration" env:"OKURU_SESSION_DURATION" help:"seconds a login lasts"`
+	WebhookUrl             string   `yaml:"webhook_url" env:"OKURU_WEBHOOK_URL" help:"url receiving the events of every share"`
+	WebhookSecret          string   `yaml:"webhook_secret" env:"OKURU_WEBHOOK_SECRET" secret:"true" help:"secret signing the webhook events"`
+	ShareWebhooks          bool     `yaml:"share_webhooks" env:"OKURU_SHARE_WEBHOOKS" help:"let the users give a webhook with their shares"`
+	WebhookRetries         int      `yaml:"webhook_retries" env:"OKURU_WEBHOOK_RETRIES" help:"retries of a failed webhook delivery"`
+	WebhookAllowedNetworks []string `yaml:"webhook_allowed_networks" env:"OKURU_WEBHOOK_ALLOWED_NETWORKS" help:"IPs or CIDR ranges of the loopback, private or link-local addresses the webhooks can reach"`
+	TrustedProxies         []string `yaml:"trusted_proxies" env:"OKURU_TRUSTED_PROXIES" help:"IPs or CIDR ranges of the reverse proxies allowed to give the client IP"`
+
+	RateLimit       bool   `yaml:"rate_limit" env:"OKURU_RATE_LIMIT" help:"limit the requests per client IP"`
+	RateLimitStore  string `yaml:"rate_limit_store" env:"OKURU_RATE_LIMIT_STORE" help:"where the rate limits are counted: memory or redis"`
+	RateLimitCreate int    `yaml:"rate_limit_create" env:"OKURU_RATE_LIMIT_CREATE" help:"passwords a client can create per minute"`
+	RateLimitReveal int    `yaml:"rate_limit_reveal" env:"OKURU_RATE_LIMIT_REVEAL" help:"passwords a client can reveal or files it can download per minute"`
+	RateLimitUpload int    `yaml:"rate_limit_upload" env:"OKURU_RATE_LIMIT_UPLOAD" help:"file uploads a client can start per minute"`
+
+	// Not a setting, the services opened from the settings, given to router.New
+	Services Services `yaml:"-"`
+}
+
+// Highest TTL (one year) and views a configuration can allow
+const (
+	maxTtl   = 31536000
+	maxViews = 1000000
+)
+
+/**
+ * Configuration used when nothing is set
+ */
+func DefaultConfig() *Config {
+	return &Config{
+		AppPort:         4000,
+		ShutdownTimeout: 30,
+		TokenSeparator:  "~",
+		AppName:         "送る",
+		AcmeCacheDir:    "data/acme",
+		HstsMaxAge:      31536000,
+		Disclaimer:      `THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR\nIMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,\nFITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE\nAUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER\nLIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,\nOUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE\nSOFTWARE.`,
+
+		Store:             "redis",
+		BoltPath:          "data/okuru.db",
+		BoltSweepInterval: 10,
+		RedisHost:         "localhost",
+		RedisPort:         6379,
+		RedisPrefix:       "okuru_",
+		RedisMaxIdle:      80,
+		RedisMaxActive:    12000,
+		RedisIdleTimeout:  240,
+		RedisTimeout:      5,
+
+		FileFolder:       "data/",
+		UploadExpiration: 86400,
+		MaxFileSize:      1024,
+		BlobStorage:      "local",
+		S3UseSsl:         true,
+
+		PasswordTtlMin:       300,
+		PasswordTtlMax:       604800,
+		PasswordTtlDefault:   3600,
+		PasswordViewsMin:     1,
+		PasswordViewsMax:     100,
+		PasswordViewsDefault: 1,
+		FileTtlMin:           300,
+		FileTtlMax:           604800,
+		FileTtlDefault:       3600,
+		FileViewsMin:         1,
+		FileViewsMax:         100,
+		FileViewsDefault:     1,
+
+		MaxFailedAttempts: 5,
+		OidcScopes:        []string{"openid", "email", "profile"},
+		SessionSecret:     RandomToken(),
+		SessionDuration:   28800,
+		WebhookRetries:    5,
+
+		RateLimit:       true,
+		RateLimitStore:  "memory",
+		RateLimitCreate: 20,
+		RateLimitReveal: 60,
+		RateLimitUpload: 10,
+	}
+}
+
+/**
+ * Error of a configuration that can't be used, it lists every problem found
+ */
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration :\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func (e *ConfigError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+/**
+ * Load the configuration from the defaults, the yaml file, the environment and the flags in args, then validate it.
+ * The file is given with --config or OKURU_CONFIG.
+ */
+func LoadConfig(args []string) (*Config, error) {
+	c := DefaultConfig()
+	flags := pflag.NewFlagSet("okuru", pflag.ExitOnError)
+	path := flags.String("config", os.Getenv("OKURU_CONFIG"), "yaml configuration file, defaults to OKURU_CONFIG")
+	c.eachOption(func(option configOption) {
+		flags.String(option.flag, "", option.help+" ("+option.env+")")
+		if option.value.Kind() == reflect.Bool {
+			flags.Lookup(option.flag).NoOptDefVal = "true"
+		}
+	})
+	flags.Parse(args)
+
+	if *path != "" {
+		if err := c.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	problems := new(ConfigError)
+	c.eachOption(func(option configOption) {
+		if value := os.Getenv(option.env); value != "" {
+			if err := option.set(value); err != nil {
+				problems.add("%s : %v", option.env, err)
+			}
+		}
+	})
+	c.eachOption(func(option configOption) {
+		if flag := flags.Lookup(option.flag); flag.Changed {
+			if err := option.set(flag.Value.String()); err != nil {
+				problems.add("--%s : %v", option.flag, err)
+			}
+		}
+	})
+	if err := c.Validate(); err != nil {
+		problems.Problems = append(problems.Problems, err.(*ConfigError).Problems...)
+	}
+	if len(problems.Problems) > 0 {
+		return nil, problems
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("can't read the configuration file : %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("invalid configuration file %s : %w", path, err)
+	}
+	return nil
+}
+
+/**
+ * Check the values, every problem is reported with the name of its setting
+ */
+func (c *Config) Validate() error {
+	problems := new(ConfigError)
+	oneOf := func(key, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		problems.add("%s is %q, it must be one of %s", c.describe(key), value, strings.Join(allowed, ", "))
+	}
+	between := func(key string, value, min, max int) {
+		if value < min || value > max {
+			problems.add("%s is %d, it must be between %d and %d", c.describe(key), value, min, max)
+		}
+	}
+	positive := func(key string, value int) {
+		if value <= 0 {
+			problems.add("%s is %d, it must be greater than 0", c.describe(key), value)
+		}
+	}
+	required := func(key, value, reason string) {
+		if value == "" {
+			problems.add("%s is needed %s", c.describe(key), reason)
+		}
+	}
+
+	between("app_port", c.AppPort, 1, 65535)
+	positive("shutdown_timeout", c.ShutdownTimeout)
+	if c.BaseUrl != "" {
+		u, err := url.Parse(c.BaseUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+			problems.add("%s must be an http or https url without query, e.g. https://example.com/okuru", c.describe("base_url"))
+		}
+	}
+	if (c.TlsCert == "") != (c.TlsKey == "") {
+		problems.add("%s and %s must be given together", c.describe("tls_cert"), c.describe("tls_key"))
+	}
+	if c.TlsCert != "" && len(c.AcmeDomains) > 0 {
+		problems.add("%s can't be used with %s, choose the certificate files or ACME", c.describe("tls_cert"), c.describe("acme_domains"))
+	}
+	if c.TlsEnabled() {
+		if c.NoSsl {
+			problems.add("%s can't be used when Okuru serves https itself", c.describe("no_ssl"))
+		}
+		if len(c.AcmeDomains) > 0 {
+			required("acme_cache_dir", c.AcmeCacheDir, "to keep the ACME certificates")
+		}
+		if c.HttpPort != 0 {
+			between("http_port", c.HttpPort, 1, 65535)
+			if c.HttpPort == c.AppPort {
+				problems.add("%s must be different from %s", c.describe("http_port"), c.describe("app_port"))
+			}
+		}
+	} else if c.HttpPort != 0 {
+		problems.add("%s is only used with %s or %s", c.describe("http_port"), c.describe("tls_cert"), c.describe("acme_domains"))
+	}
+	if c.AcmeDirectory != "" {
+		if u, err := url.Parse(c.AcmeDirectory); err != nil || u.Scheme != "https" || u.Host == "" {
+			problems.add("%s must be an https url", c.describe("acme_directory"))
+		}
+	}
+	between("hsts_max_age", c.HstsMaxAge, 0, 63072000)
+	if c.TokenSeparator == "" || strings.ContainsAny(c.TokenSeparator, "/?#") {
+		problems.add("%s can't be empty nor contain /, ? or #", c.describe("token_separator"))
+	}
+
+	oneOf("store", c.Store, "redis", "bolt", "memory")
+	positive("bolt_sweep_interval", c.BoltSweepInterval)
+	between("redis_port", c.RedisPort, 1, 65535)
+	if c.RedisDb < 0 {
+		problems.add("%s is %d, it can't be negative", c.describe("redis_db"), c.RedisDb)
+	}
+	between("redis_max_idle", c.RedisMaxIdle, 0, 1000000)
+	between("redis_max_active", c.RedisMaxActive, 0, 1000000)
+	between("redis_idle_timeout", c.RedisIdleTimeout, 0, 86400)
+	positive("redis_timeout", c.RedisTimeout)
+	if c.RedisUrl != "" {
+		if u, err := url.Parse(c.RedisUrl); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") {
+			problems.add("%s must be a redis:// or rediss:// url", c.describe("redis_url"))
+		}
+	}
+	if c.RedisSentinelMaster != "" && len(c.RedisSentinelAddrs) == 0 {
+		problems.add("%s is needed with %s", c.describe("redis_sentinel_addrs"), c.describe("redis_sentinel_master"))
+	}
+
+	positive("upload_expiration", c.UploadExpiration)
+	positive("max_file_size", c.MaxFileSize)
+	oneOf("blob_storage", c.BlobStorage, "local", "s3")
+	if c.BlobStorage == "s3" {
+		required("s3_endpoint", c.S3Endpoint, "with the s3 blob storage")
+		required("s3_bucket", c.S3Bucket, "with the s3 blob storage")
+	}
+
+	limits := func(kind string, ttlMin, ttlMax, ttlDefault, viewsMin, viewsMax, viewsDefault int) {
+		positive(kind+"_ttl_min", ttlMin)
+		between(kind+"_ttl_max", ttlMax, ttlMin, maxTtl)
+		between(kind+"_ttl_default", ttlDefault, ttlMin, ttlMax)
+		positive(kind+"_views_min", viewsMin)
+		between(kind+"_views_max", viewsMax, viewsMin, maxViews)
+		between(kind+"_views_default", viewsDefault, viewsMin, viewsMax)
+	}
+	limits("password", c.PasswordTtlMin, c.PasswordTtlMax, c.PasswordTtlDefault, c.PasswordViewsMin, c.PasswordViewsMax, c.PasswordViewsDefault)
+	limits("file", c.FileTtlMin, c.FileTtlMax, c.FileTtlDefault, c.FileViewsMin, c.FileViewsMax, c.FileViewsDefault)
+
+	between("max_failed_attempts", c.MaxFailedAttempts, 1, 100)
+	if c.OidcIssuer != "" {
+		required("oidc_client_id", c.OidcClientId, "with "+c.describe("oidc_issuer"))
+		if len(c.OidcScopes) == 0 {
+			problems.add("%s can't be empty, openid is needed", c.describe("oidc_scopes"))
+		}
+	}
+	if c.SessionSecret == "" {
+		problems.add("%s can't be empty, leave it unset to get a random one", c.describe("session_secret"))
+	}
+	positive("session_duration", c.SessionDuration)
+	if c.WebhookUrl != "" {
+		if u, err := url.Parse(c.WebhookUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems.add("%s must be an http or https url", c.describe("webhook_url"))
+		}
+	}
+	between("webhook_retries", c.WebhookRetries, 0, 20)
+	for _, allowed := range c.WebhookAllowedNetworks {
+		if _, err := ParseNetwork(allowed); err != nil {
+			problems.add("%s : %q isn't an IP nor a CIDR range", c.describe("webhook_allowed_networks"), allowed)
+		}
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, err := ParseNetwork(proxy); err != nil {
+			problems.add("%s : %q isn't an IP nor a CIDR range", c.describe("trusted_proxies"), proxy)
+		}
+	}
+
+	oneOf("rate_limit_store", c.RateLimitStore, "memory", "redis")
+	if c.RateLimit && c.RateLimitStore == "redis" && c.Store != "redis" {
+		problems.add("%s redis needs %s redis, the limits are counted with its connections", c.describe("rate_limit_store"), c.describe("store"))
+	}
+	positive("rate_limit_create", c.RateLimitCreate)
+	positive("rate_limit_reveal", c.RateLimitReveal)
+	positive("rate_limit_upload", c.RateLimitUpload)
+
+	if len(problems.Problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+/**
+ * Copy of the configuration without its secrets, to be logged
+ */
+func (c *Config) Redacted() Config {
+	redacted := *c
+	redacted.Services = Services{}
+	redacted.eachOption(func(option configOption) {
+		if option.secret && option.value.String() != "" {
+			option.value.SetString("***")
+		}
+	})
+	return redacted
+}
+
+/**
+ * A setting of Config with the names it has in the file, the environment and the flags
+ */
+type configOption struct {
+	key    string
+	env    string
+	flag   string
+	help   string
+	secret bool
+	value  reflect.Value
+}
+
+func (c *Config) eachOption(fn func(option configOption)) {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("yaml")
+		if key == "-" {
+			continue
+		}
+		fn(configOption{
+			key:    key,
+			env:    field.Tag.Get("env"),
+			flag:   strings.Replace(key, "_", "-", -1),
+			help:   field.Tag.Get("help"),
+			secret: field.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+}
+
+/**
+ * Name of a setting as it can be set, e.g. max_file_size (OKURU_MAX_FILE_SIZE, --max-file-size)
+ */
+func (c *Config) describe(key string) string {
+	description := key
+	c.eachOption(func(option configOption) {
+		if option.key == key {
+			description = option.key + " (" + option.env + ", --" + option.flag + ")"
+		}
+	})
+	return description
+}
+
+/**
+ * Set the value of the option from its text form, as given by the environment or a flag
+ */
+func (option configOption) set(value string) error {
+	switch option.value.Kind() {
+	case reflect.String:
+		option.value.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q isn't a boolean, use true or false", value)
+		}
+		option.value.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q isn't a number", value)
+		}
+		option.value.SetInt(int64(n))
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		option.value.Set(reflect.ValueOf(list))
+	}
+	return nil
+}
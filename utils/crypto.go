@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/fernet/fernet-go"
+	"io"
+)
+
+/*
+ * Files are too big to be encrypted with Fernet in one go, so they are encrypted as a stream of AES-GCM chunks.
+ * The AES key is derived from the Fernet key of the share (the one in the URL) and a random salt stored in the header.
+ * Each chunk nonce is its counter followed by a flag set on the last chunk, so chunks can't be reordered or truncated.
+ */
+const (
+	fileMagic     = "OKURU1"
+	fileSaltSize  = 16
+	fileChunkSize = 64 * 1024
+)
+
+var ErrFileCorrupted = errors.New("encrypted file is corrupted or the key is wrong")
+
+func fileCipher(key string, salt []byte) (cipher.AEAD, error) {
+	k, err := fernet.DecodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, k[:])
+	mac.Write([]byte("okuru file encryption"))
+	mac.Write(salt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+}
+
+/**
+ * Return a writer encrypting everything written to w with the given share key.
+ * Close must be called to write the last chunk, it doesn't close w.
+ * @param w
+ * @param key fernet encoded key
+ */
+func NewEncryptWriter(w io.Writer, key string) (io.WriteCloser, error) {
+	salt := make([]byte, fileSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := fileCipher(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append([]byte(fileMagic), salt...)); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, fileChunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		// Only flush a full chunk once we know more data follows, the last chunk is written by Close
+		if len(e.buf) == fileChunkSize {
+			if err := e.flush(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(e.buf[len(e.buf):fileChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.flush(true)
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	_, err := e.w.Write(e.aead.Seal(nil, chunkNonce(e.counter, last), e.buf, nil))
+	e.counter++
+	e.buf = e.buf[:0]
+	return err
+}
+
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	chunk   []byte
+	buf     []byte
+	counter uint64
+	done    bool
+}
+
+/**
+ * Return a reader decrypting r, written with NewEncryptWriter, with the given share key.
+ * @param r
+ * @param key fernet encoded key
+ */
+func NewDecryptReader(r io.Reader, key string) (io.Reader, error) {
+	header := make([]byte, len(fileMagic)+fileSaltSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrFileCorrupted
+	}
+	if string(header[:len(fileMagic)]) != fileMagic {
+		return nil, ErrFileCorrupted
+	}
+	aead, err := fileCipher(key, header[len(fileMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:     bufio.NewReader(r),
+		aead:  aead,
+		chunk: make([]byte, fileChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	var last bool
+	n, err := io.ReadFull(d.r, d.chunk)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		last = true
+	} else if err != nil {
+		return err
+	} else {
+		_, err := d.r.Peek(1)
+		if err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plain, err := d.aead.Open(d.chunk[:0], chunkNonce(d.counter, last), d.chunk[:n], nil)
+	if err != nil {
+		return ErrFileCorrupted
+	}
+	d.counter++
+	d.buf = plain
+	d.done = last
+	return nil
+}
+
+/*
+ * Client encrypted passwords are encrypted by the browser or the CLI with AES-256-GCM and a random key kept in the
+ * URL fragment (base64url without padding). The server only gets base64(nonce + ciphertext) and can't decrypt it.
+ */
+const (
+	clientNonceSize = 12
+	clientTagSize   = 16
+)
+
+/**
+ * Check a client encrypted password looks like a ciphertext, so a client can't store a plaintext by mistake.
+ * @param ciphertext
+ */
+func ValidCiphertext(ciphertext string) bool {
+	raw, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return false
+	}
+	return len(raw) >= clientNonceSize+clientTagSize
+}
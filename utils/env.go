@@ -2,115 +2,138 @@ package utils
 
 import (
 	"github.com/flosch/pongo2"
-	"github.com/labstack/gommon/log"
-	"os"
+	"github.com/labstack/echo"
+	"net"
+	"net/url"
 	"path/filepath"
-	"strconv"
 	"strings"
 )
 
-var (
-	REDIS_HOST string
-	REDIS_PASSWORD string
-	REDIS_PORT string
-	REDIS_DB string
-	REDIS_PREFIX string
-	TOKEN_SEPARATOR string
-	NO_SSL bool = false
-	APP_PORT string
-	LOGO string
-	APP_NAME string
-	DISCLAIMER string
-	COPYRIGHT string
-	FILEFOLDER string
-	MAXFILESIZE string
-	MaxFileSize int64
-	DataContext pongo2.Context
-)
+// Key of the configuration in the echo context, set by middlewares.SetConfig
+const configKey = "config"
 
-func init() {
-	if REDIS_HOST = os.Getenv("REDIS_HOST"); REDIS_HOST == "" {
-		REDIS_HOST = "localhost"
-	}
-	if REDIS_PASSWORD = os.Getenv("REDIS_PASSWORD"); REDIS_PASSWORD == "" {
-		REDIS_PASSWORD = ""
-	}
-	if REDIS_PORT = os.Getenv("REDIS_PORT"); REDIS_PORT == "" {
-		REDIS_PORT = "6379"
-	}
-	if REDIS_DB = os.Getenv("REDIS_DB"); REDIS_DB == "" {
-		REDIS_DB = "0"
-	}
-	if REDIS_PREFIX = os.Getenv("REDIS_PREFIX"); REDIS_PREFIX == "" {
-		REDIS_PREFIX = "okuru_"
-	}
-	if TOKEN_SEPARATOR = os.Getenv("OKURU_TOKEN_SEPARATOR"); TOKEN_SEPARATOR == "" {
-		TOKEN_SEPARATOR = "~"
-	}
-	if NoSslEnv := os.Getenv("NO_SSL"); NoSslEnv == "" {
-		NO_SSL = false
-	}
-	if APP_PORT = os.Getenv("OKURU_APP_PORT"); APP_PORT == "" {
-		APP_PORT = "4000"
-	}
-	if LOGO = os.Getenv("OKURU_LOGO"); LOGO == "" {
-		LOGO = ""
-	}
-	if APP_NAME = os.Getenv("OKURU_APP_NAME"); APP_NAME == "" {
-		APP_NAME = "送る"
-	}
-	if DISCLAIMER = os.Getenv("OKURU_DISCLAIMER"); DISCLAIMER == "" {
-		DISCLAIMER = `THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR\nIMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,\nFITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE\nAUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER\nLIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,\nOUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE\nSOFTWARE.`
-	}
-	if COPYRIGHT = os.Getenv("OKURU_COPYRIGHT"); COPYRIGHT == "" {
-		COPYRIGHT = ``
-	}
-	if FILEFOLDER = os.Getenv("OKURU_FILE_FOLDER"); FILEFOLDER == "" {
-		FILEFOLDER = "data/"
-	}
-	if MAXFILESIZE = os.Getenv("OKURU_MAX_FILE_SIZE"); MAXFILESIZE == "" {
-		MAXFILESIZE = "1024"
-	}
+/**
+ * Configuration of the server handling the request
+ */
+func GetConfig(context echo.Context) *Config {
+	return context.Get(configKey).(*Config)
+}
+
+/**
+ * Make the configuration available to the handlers of the request with GetConfig
+ */
+func SetConfig(context echo.Context, c *Config) {
+	context.Set(configKey, c)
+}
+
+/**
+ * Bounds and defaults of the TTL and the views of the passwords
+ */
+func (c *Config) PasswordLimits() Limits {
+	return Limits{
+		TtlMin:       c.PasswordTtlMin,
+		TtlMax:       c.PasswordTtlMax,
+		TtlDefault:   c.PasswordTtlDefault,
+		ViewsMin:     c.PasswordViewsMin,
+		ViewsMax:     c.PasswordViewsMax,
+		ViewsDefault: c.PasswordViewsDefault,
+	}
+}
+
+/**
+ * Bounds and defaults of the TTL and the views of the files
+ */
+func (c *Config) FileLimits() Limits {
+	return Limits{
+		TtlMin:       c.FileTtlMin,
+		TtlMax:       c.FileTtlMax,
+		TtlDefault:   c.FileTtlDefault,
+		ViewsMin:     c.FileViewsMin,
+		ViewsMax:     c.FileViewsMax,
+		ViewsDefault: c.FileViewsDefault,
+	}
+}
 
-	FILEFOLDER, _ = filepath.Abs(FILEFOLDER)
-	var err error
-	MaxFileSize, err = strconv.ParseInt(MAXFILESIZE, 10, 64)
+/**
+ * Max size of an upload in bytes, max_file_size is in megabytes
+ */
+func (c *Config) MaxFileSizeBytes() int64 {
+	return int64(c.MaxFileSize) * 1024 * 1024
+}
+
+/**
+ * Folder of the resumable uploads, the uploads folder of file_folder when upload_folder isn't set
+ */
+func (c *Config) UploadDir() string {
+	folder := c.UploadFolder
+	if folder == "" {
+		folder = filepath.Join(c.FileFolder, "uploads")
+	}
+	folder, _ = filepath.Abs(folder)
+	return folder
+}
+
+/**
+ * OKURU_BASE_URL without its trailing slash, empty when the links are made from the requests
+ */
+func (c *Config) PublicUrl() string {
+	return strings.TrimSuffix(c.BaseUrl, "/")
+}
+
+/**
+ * Path Okuru is served under, from OKURU_BASE_URL. Empty when it's served at the root.
+ */
+func (c *Config) BasePath() string {
+	u, err := url.Parse(c.PublicUrl())
 	if err != nil {
-		MaxFileSize = 1024
+		return ""
 	}
-	MaxFileSize = MaxFileSize * 1024 * 1024 // bytes to megabytes
+	return u.Path
+}
 
-	log.Debug("REDIS_HOST : %+v\n", REDIS_HOST)
-	/*println("")
-	log.Debug("REDIS_PASSWORD : %+v\n", REDIS_PASSWORD)*/
-	println("")
-	log.Debug("REDIS_PORT : %+v\n", REDIS_PORT)
-	println("")
-	log.Debug("REDIS_DB : %+v\n", REDIS_DB)
-	println("")
-	log.Debug("REDIS_PREFIX : %+v\n", REDIS_PREFIX)
-	println("")
-	log.Debug("TOKEN_SEPARATOR : %+v\n", TOKEN_SEPARATOR)
-	println("")
-	log.Debug("NO_SSL : %+v\n", NO_SSL)
-	println("")
-	log.Debug("File folder : %+v\n", FILEFOLDER)
-	println("")
-	log.Debug("APP_PORt : %+v\n", APP_PORT)
-	println("")
-	log.Debug("COPYRIGHT : %+v\n", COPYRIGHT)
-	println("")
-	log.Debug("LOGO : %+v\n", LOGO)
-	println("")
-	log.Debug("DISCLAIMER : %+v\n", DISCLAIMER)
-	println("")
-	log.Debug("APP_NAME : %+v\n", APP_NAME)
+/**
+ * True when ip is one of OKURU_TRUSTED_PROXIES
+ */
+func (c *Config) trustedProxy(ip net.IP) bool {
+	for _, proxy := range c.TrustedProxies {
+		if network, err := ParseNetwork(proxy); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
 
-	//Init data context that'll be passed to render to avoid creating it every time for those "global" variable
-	DataContext = pongo2.Context{
-		"logo": LOGO,
-		"APP_NAME": APP_NAME,
-		"disclaimer": "<p>" + strings.Replace(DISCLAIMER, "\\n", "<br>", -1) + "<p>",
-		"copyright": "<p>" + COPYRIGHT + "<p>",
-	}
-}
\ No newline at end of file
+/**
+ * True when ip is in OKURU_WEBHOOK_ALLOWED_NETWORKS
+ */
+func (c *Config) webhookAllowed(ip net.IP) bool {
+	for _, allowed := range c.WebhookAllowedNetworks {
+		if network, err := ParseNetwork(allowed); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Values given to every page, a new context is returned so each request can add its own values
+ */
+func (c *Config) DataContext() pongo2.Context {
+	passwordLimits := c.PasswordLimits()
+	fileLimits := c.FileLimits()
+	return pongo2.Context{
+		"logo":              c.Logo,
+		"APP_NAME":          c.AppName,
+		"basePath":          c.BasePath(),
+		"disclaimer":        "<p>" + strings.Replace(c.Disclaimer, "\\n", "<br>", -1) + "<p>",
+		"copyright":         "<p>" + c.Copyright + "<p>",
+		"passwordLimits":    passwordLimits,
+		"passwordTtls":      passwordLimits.TtlOptions(),
+		"fileLimits":        fileLimits,
+		"fileTtls":          fileLimits.TtlOptions(),
+		"maxFailedAttempts": c.MaxFailedAttempts,
+		"apiKeyRequired":    c.ApiKeys,
+		"oidc":              c.OidcIssuer != "",
+		"shareWebhooks":     c.ShareWebhooks,
+	}
+}
@@ -0,0 +1,305 @@
+package utils
+
+import (
+	"archive/zip"
+	"fmt"
+	"github.com/eraffaelli/Okuru/models"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"time"
+)
+
+// Form values are small, anything bigger isn't a legit field
+const maxFormValueSize = 64 * 1024
+
+/**
+ * Read a multipart upload part by part, the files of fileField are zipped straight into the encrypted archive
+ * of token (FILEFOLDER/storageKey.zip) and the other form values are returned.
+ * Files are never buffered, the upload is rejected as soon as it goes over max_file_size.
+ * @param reader
+ * @param fileField
+ * @param token from NewFileToken
+ */
+func StreamFiles(config *Config, reader *multipart.Reader, fileField string, token string) (url.Values, *echo.HTTPError) {
+	values := url.Values{}
+	err := createArchive(config, token, func(z *zip.Writer) error {
+		return readParts(config, z, reader, fileField, values)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+/**
+ * Zip a single file, like an upload assembled on the disk, into the encrypted archive of token.
+ * @param src
+ * @param name of the file in the archive
+ * @param token from NewFileToken
+ */
+func ArchiveFile(config *Config, src io.Reader, name string, token string) *echo.HTTPError {
+	return createArchive(config, token, func(z *zip.Writer) error {
+		w, err := createEntry(z, name)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, src)
+		return err
+	})
+}
+
+/**
+ * Create the encrypted archive of token and let fill write the files in it, the archive is removed if anything fails.
+ * fill can return an *echo.HTTPError to reject the upload with a specific message.
+ */
+func createArchive(config *Config, token string, fill func(z *zip.Writer) error) *echo.HTTPError {
+	storageKey, encryptionKey, err := ParseToken(config, token)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	dst, err := config.Services.Blobs.Create(storageKey + ".zip")
+	if err != nil {
+		log.Errorf("createArchive Error while creating file : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "There was a problem during the process, please contact your administrator")
+	}
+
+	err = writeArchive(dst, encryptionKey, fill)
+	if errClose := dst.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		CleanFile(config, storageKey)
+		if httpError, ok := err.(*echo.HTTPError); ok {
+			log.Error("createArchive rejected upload : ", httpError.Message)
+			return httpError
+		}
+		log.Errorf("Error while archive : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "There was a problem during the process, please contact your administrator")
+	}
+
+	return nil
+}
+
+func writeArchive(dst io.Writer, encryptionKey string, fill func(z *zip.Writer) error) error {
+	ew, err := NewEncryptWriter(dst, encryptionKey)
+	if err != nil {
+		return err
+	}
+
+	z := zip.NewWriter(ew)
+	if err := fill(z); err != nil {
+		return err
+	}
+	if err := z.Close(); err != nil {
+		return err
+	}
+	return ew.Close()
+}
+
+func createEntry(z *zip.Writer, name string) (io.Writer, error) {
+	return z.CreateHeader(&zip.FileHeader{
+		Name:     filepath.Base(name),
+		Method:   zip.Store,
+		Modified: time.Now(),
+	})
+}
+
+func readParts(config *Config, z *zip.Writer, reader *multipart.Reader, fileField string, values url.Values) error {
+	remaining := config.MaxFileSizeBytes()
+	files := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "The upload is not a valid multipart form")
+		}
+
+		// Browsers send an empty part without file name when no file is selected
+		if part.FileName() == "" {
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxFormValueSize+1))
+			if err != nil {
+				return err
+			}
+			if len(value) > maxFormValueSize {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Form value %s is too big", part.FormName()))
+			}
+			values.Add(part.FormName(), string(value))
+			continue
+		}
+		if part.FormName() != fileField {
+			continue
+		}
+
+		w, err := createEntry(z, part.FileName())
+		if err != nil {
+			return err
+		}
+		n, err := io.CopyN(w, part, remaining+1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n > remaining {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Total upload size is greater than %s (max authorized)", GetMaxFileSizeText(config)))
+		}
+		remaining -= n
+		files++
+	}
+
+	if files == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "No file was selected")
+	}
+	return nil
+}
+
+type decryptedFile struct {
+	io.Reader
+	io.Closer
+}
+
+/**
+ * Open the archive of a file share, decrypted on the fly with the key of the token.
+ * @param token
+ */
+func OpenFile(config *Config, token string) (io.ReadCloser, error) {
+	storageKey, decryptionKey, err := ParseToken(config, token)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := config.Services.Blobs.Open(storageKey + ".zip")
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewDecryptReader(src, decryptionKey)
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+	return decryptedFile{r, src}, nil
+}
+
+/**
+ * Return the file share metadata (ttl, views left, deletable, password provided) without consuming a view.
+ */
+func GetFileInfo(config *Config, f *models.File) *echo.HTTPError {
+	storageKey, decryptionKey, err := ParseToken(config, f.FileKey)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if decryptionKey == "" {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+
+	fields, ttl, err := config.Services.Store.Get("file_" + storageKey)
+	if err != nil {
+		return storeError("GetFileInfo", err)
+	}
+
+	err = fields.Scan(f)
+	if err != nil {
+		log.Errorf("GetFileInfo() err scan struct : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	if string(f.Token) == "" {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err := CheckAllowedIps(f.AllowedIps, f.ClientIp); err != nil {
+		return err
+	}
+
+	f.TTL = ttl
+	f.Views = f.Views - f.ViewsCount
+	if f.Views < 0 {
+		f.Views = 0
+	}
+
+	return nil
+}
+
+/**
+ * Count one view of a file share previously loaded with RetrieveFilePassword.
+ * Return true when it was the last view, the share is then removed from the store but the archive is kept
+ * so it can still be sent, the caller must call CleanFile once done.
+ */
+func ConsumeFileView(config *Config, f *models.File) (bool, *echo.HTTPError) {
+	storageKey, _, err := ParseToken(config, f.FileKey)
+	if err != nil {
+		return false, echo.NewHTTPError(http.StatusNotFound)
+	}
+
+	fields, _, last, err := config.Services.Store.ConsumeView("file_" + storageKey)
+	if err != nil {
+		return false, storeError("ConsumeFileView", err)
+	}
+
+	err = fields.Scan(f)
+	if err != nil {
+		log.Errorf("ConsumeFileView() err scan struct : %+v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	syncProvidedPassword(config, f, last)
+
+	f.Views = f.Views - f.ViewsCount
+	if f.Views < 0 {
+		f.Views = 0
+	}
+	ShareEvent(config, "file_"+storageKey, EventDownloaded, f.Views, last)
+
+	return last, nil
+}
+
+/**
+ * Check the password given to download a file share loaded with RetrieveFilePassword, if one was provided at creation.
+ * Wrong passwords are counted and the share is destroyed once it reaches its max attempts.
+ */
+func CheckFilePassword(config *Config, f *models.File, password string) *echo.HTTPError {
+	if !f.PasswordProvided || ComparePassword(f.Password, password) {
+		return nil
+	}
+
+	storageKey, _, err := ParseToken(config, f.FileKey)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if failedAttempt(config, "CheckFilePassword", "file_"+storageKey, f.MaxAttempts) {
+		DiscardFile(config, f)
+		CleanFile(config, storageKey)
+		ShareEvent(config, "file_"+storageKey, EventDeleted, 0, true)
+		return echo.NewHTTPError(http.StatusUnauthorized, "Wrong password, the file was destroyed after too many attempts")
+	}
+	return echo.NewHTTPError(http.StatusUnauthorized, "Wrong password")
+}
+
+/**
+ * Remove a file share whatever its deletable value and the password share provided with it, used when the upload
+ * failed once they were stored.
+ */
+func DiscardFile(config *Config, f *models.File) {
+	storageKey, _, err := ParseToken(config, f.FileKey)
+	if err != nil {
+		return
+	}
+
+	err = config.Services.Store.Delete("file_" + storageKey)
+	if err != nil {
+		log.Errorf("DiscardFile() Store err DEL main key : %+v", err)
+	}
+	if f.PasswordProvidedKey != "" {
+		err = config.Services.Store.Delete(f.PasswordProvidedKey)
+		if err != nil {
+			log.Errorf("DiscardFile() Store err DEL password provided key : %+v", err)
+		}
+	}
+}
@@ -1,46 +1,43 @@
 package utils
 
 import (
+	"context"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
 	"github.com/eraffaelli/Okuru/models"
+	"github.com/eraffaelli/Okuru/store"
 	"github.com/fernet/fernet-go"
-	"github.com/garyburd/redigo/redis"
 	"github.com/google/uuid"
 	"github.com/labstack/echo"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
 	"math/rand"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
 /**
- * Return url with http or https based on NO_SSL env value
+ * Return the public url of Okuru that every link starts with, OKURU_BASE_URL when it's set. Otherwise it's made from
+ * the request, with the scheme it's served with, or the one of X-Forwarded-Proto behind a trusted proxy.
+ * Without trusted proxies the scheme of a plain http request can't be known, Okuru is then expected behind a reverse
+ * proxy serving https and the links are https unless NO_SSL is set.
  */
 func GetBaseUrl(context echo.Context) string {
-	r := context.Request()
-	currentURL := context.Scheme() + "://" + r.Host
-	var url string
-	if !NO_SSL && context.Scheme() == "http" {
-		url = strings.Replace(currentURL, "http", "https", -1)
-	} else {
-		url = currentURL
+	config := GetConfig(context)
+	if publicUrl := config.PublicUrl(); publicUrl != "" {
+		return publicUrl
 	}
-	return url
-}
-
-/**
- * Transforme TTL from form to seconds
- */
-func GetTtlSeconds(ttl int) int {
-	if ttl >= 1 && ttl <= 24 {
-		ttl = ttl * 3600
-	} else if ttl > 24 && ttl <= 30 {
-		ttl = (ttl - 23) * 86400
+	scheme, host := forwardedOrigin(context)
+	if scheme == "http" && !config.NoSsl && len(config.TrustedProxies) == 0 {
+		scheme = "https"
 	}
-	return ttl
+	return scheme + "://" + host
 }
 
 /*
@@ -61,8 +58,8 @@ func GetTTLText(ttl int) (ttlText string) {
 	return
 }
 
-func GetMaxFileSizeText() string {
-	size := MaxFileSize / 1024 / 1024
+func GetMaxFileSizeText(config *Config) string {
+	size := config.MaxFileSizeBytes() / 1024 / 1024
 	var text string
 	if size >= 1024 {
 		text = strconv.FormatInt(size/1024, 10) + " GB"
@@ -76,8 +73,8 @@ func GetMaxFileSizeText() string {
  * Return splitted token
  * @param token
  */
-func ParseToken(token string) (string, string, error) {
-	tokenFragments := strings.Split(token, TOKEN_SEPARATOR)
+func ParseToken(config *Config, token string) (string, string, error) {
+	tokenFragments := strings.Split(token, config.TokenSeparator)
 	if len(tokenFragments) != 2 {
 		return "", "", errors.New("not enough token fragments")
 	}
@@ -86,19 +83,28 @@ func ParseToken(token string) (string, string, error) {
 
 /**
 Take a password string, encrypt it with Fernet symmetric encryption and return the result (bytes), with the decryption key (bytes)
+With a passphrase, the password is encrypted with a key derived from both the decryption key and the passphrase.
 * @param password
+* @param passphrase (optional)
+* @param salt base64 salt of the passphrase derivation
 */
-func Encrypt(password string) ([]byte, string, error) {
+func Encrypt(password string, passphrase string, salt string) ([]byte, string, error) {
 	var k fernet.Key
 	err := k.Generate()
 	if err != nil {
-		log.Error("Encrypt() Generate err : %+v\n", err)
+		log.Errorf("Encrypt() Generate err : %+v", err)
+		return nil, "", err
+	}
+
+	sealingKey, err := passphraseKey(&k, passphrase, salt)
+	if err != nil {
+		log.Errorf("Encrypt() passphrase key err : %+v", err)
 		return nil, "", err
 	}
 
-	tok, err := fernet.EncryptAndSign([]byte(password), &k)
+	tok, err := fernet.EncryptAndSign([]byte(password), sealingKey)
 	if err != nil {
-		log.Error("Encrypt() EncryptAndSign err : %+v\n", err)
+		log.Errorf("Encrypt() EncryptAndSign err : %+v", err)
 		return nil, "", err
 	}
 
@@ -107,88 +113,265 @@ func Encrypt(password string) ([]byte, string, error) {
 
 /**
  * Decrypt a password (bytes) using the provided key (bytes) and return the plain-text password (bytes).
+ * The expiration is handled by the store, so the age of the fernet token isn't checked.
  * @param password
  * @param decryption_key
+ * @param passphrase (optional)
+ * @param salt base64 salt of the passphrase derivation, empty if the password has no passphrase
  */
-func Decrypt(password []byte, decryptionKey string, ttl int) (string, error) {
-	k, err := fernet.DecodeKeys(decryptionKey)
+func Decrypt(password []byte, decryptionKey string, passphrase string, salt string) (string, error) {
+	k, err := fernet.DecodeKey(decryptionKey)
+	if err != nil {
+		return "", err
+	}
+	k, err = passphraseKey(k, passphrase, salt)
 	if err != nil {
 		return "", err
 	}
-	message := fernet.VerifyAndDecrypt(password, time.Duration(ttl)*time.Second, k)
-	return string(message), err
+	message := fernet.VerifyAndDecrypt(password, 0, []*fernet.Key{k})
+	if message == nil {
+		return "", errors.New("invalid key or passphrase")
+	}
+	return string(message), nil
+}
+
+/**
+ * Mix the passphrase into the key, scrypt makes each guess of the passphrase expensive.
+ * The key is returned as is when there is no salt, meaning the password has no passphrase.
+ */
+func passphraseKey(k *fernet.Key, passphrase string, salt string) (*fernet.Key, error) {
+	if salt == "" {
+		return k, nil
+	}
+	rawSalt, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return nil, err
+	}
+	stretched, err := scrypt.Key([]byte(passphrase), rawSalt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, k[:])
+	mac.Write([]byte("okuru passphrase"))
+	mac.Write(stretched)
+	derived := new(fernet.Key)
+	copy(derived[:], mac.Sum(nil))
+	return derived, nil
+}
+
+/**
+ * Compare passwords in constant time, hashed first so the length doesn't leak either
+ */
+func ComparePassword(expected string, given string) bool {
+	e := sha256.Sum256([]byte(expected))
+	g := sha256.Sum256([]byte(given))
+	return subtle.ConstantTimeCompare(e[:], g[:]) == 1
+}
+
+/**
+ * Number of wrong passwords a share accepts before being destroyed, OKURU_MAX_FAILED_ATTEMPTS if not set
+ */
+func GetMaxAttempts(config *Config, maxAttempts int) int {
+	if maxAttempts <= 0 {
+		return config.MaxFailedAttempts
+	}
+	return maxAttempts
+}
+
+/**
+ * Parse the optional number of wrong passwords a share accepts, 0 if empty so the default is used
+ */
+func ParseMaxAttempts(value string) (int, *echo.HTTPError) {
+	if value == "" {
+		return 0, nil
+	}
+	maxAttempts, err := strconv.Atoi(value)
+	if err != nil || maxAttempts < 1 || maxAttempts > 100 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Max attempts out of range (min 1, max 100)")
+	}
+	return maxAttempts, nil
+}
+
+/**
+ * Count a wrong password on the share stored under key, return true when it reached maxAttempts and must be destroyed
+ */
+func failedAttempt(config *Config, function string, key string, maxAttempts int) bool {
+	attempts, err := config.Services.Store.Increment(key, "failed_attempts")
+	if err != nil {
+		if err != store.ErrNotFound {
+			log.Error(function, "() Store err increment failed attempts : ", err)
+		}
+		return false
+	}
+	if attempts < GetMaxAttempts(config, maxAttempts) {
+		return false
+	}
+	log.Warn(function, "() share destroyed after ", attempts, " failed attempts")
+	return true
+}
+
+/**
+ * Random salt for the passphrase derivation, base64 encoded to be stored
+ */
+func NewSalt() (string, error) {
+	salt := make([]byte, 16)
+	if _, err := cryptorand.Read(salt); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(salt), nil
 }
 
 /**
  * Encrypt and store the password for the specified lifetime.
  * Returns a token comprised of the key where the encrypted password is stored, and the decryption key.
+ * The passphrase isn't stored, it must be given again to reveal the password.
  * @param {string} password
  * @param {number} ttl
  * @param {number} views
  * @param {boolean} deletable
+ * @param {string} passphrase (optional)
+ * @param {number} maxAttempts wrong passphrases before the password is destroyed, 0 for OKURU_MAX_FAILED_ATTEMPTS
+ * @param {string} allowedIps networks the password can be read from, from ParseAllowedIps (optional)
  * @return {string, error} token, error
  */
-func SetPassword(password string, ttl int, views int, deletable bool) (string, *echo.HTTPError) {
-	pool := NewPool()
-	c := pool.Get()
-	defer c.Close()
-	if Ping(c) == false {
-		println("Ping failed")
-		return "", echo.NewHTTPError(http.StatusInternalServerError)
-	}
-
+func SetPassword(config *Config, password string, ttl int, views int, deletable bool, passphrase string, maxAttempts int, allowedIps string) (string, *echo.HTTPError) {
 	storageKey := uuid.New()
 
-	encryptedPassword, encryptionKey, err := Encrypt(password)
+	fields := store.Fields{
+		"views":       strconv.Itoa(views),
+		"views_count": "0",
+		"deletable":   strconv.FormatBool(deletable),
+		"allowed_ips": allowedIps,
+	}
 
+	var salt string
+	if passphrase != "" {
+		var err error
+		salt, err = NewSalt()
+		if err != nil {
+			return "", echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		fields["passphrase"] = "true"
+		fields["salt"] = salt
+		fields["max_attempts"] = strconv.Itoa(GetMaxAttempts(config, maxAttempts))
+		fields["failed_attempts"] = "0"
+	}
+
+	encryptedPassword, encryptionKey, err := Encrypt(password, passphrase, salt)
 	if err != nil {
 		return "", echo.NewHTTPError(http.StatusInternalServerError)
 	}
+	fields["token"] = string(encryptedPassword)
 
-	_, err = c.Do("HMSET", REDIS_PREFIX+storageKey.String(),
-		"token", encryptedPassword,
-		"views", views,
-		"views_count", 0,
-		"deletable", deletable)
+	err = config.Services.Store.Put(storageKey.String(), fields, ttl)
 	if err != nil {
-		log.Error("SetPassword() Redis err set : %+v\n", err)
+		log.Errorf("SetPassword() Store err put : %+v", err)
 		return "", echo.NewHTTPError(http.StatusInternalServerError)
 	}
 
-	_, err = c.Do("EXPIRE", REDIS_PREFIX+storageKey.String(), ttl)
-	if err != nil {
-		log.Error("SetPassword() Redis err expire : %+v\n", err)
+	return storageKey.String() + config.TokenSeparator + encryptionKey, nil
+}
+
+/**
+ * Store a secret encrypted by the client, the server never sees its key which stays in the URL fragment.
+ * Returns the storage key, the link is made of it followed by #key on the client side.
+ * @param {string} ciphertext base64 of the AES-GCM nonce and ciphertext
+ * @param {number} ttl
+ * @param {number} views
+ * @param {boolean} deletable
+ * @param {string} allowedIps networks the password can be read from, from ParseAllowedIps (optional)
+ * @return {string, error} storage key, error
+ */
+func SetClientPassword(config *Config, ciphertext string, ttl int, views int, deletable bool, allowedIps string) (string, *echo.HTTPError) {
+	if !ValidCiphertext(ciphertext) {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "The password must be the base64 of the nonce and the AES-GCM ciphertext")
+	}
+
+	storageKey := uuid.New()
+	err := config.Services.Store.Put(storageKey.String(), store.Fields{
+		"token":            ciphertext,
+		"views":            strconv.Itoa(views),
+		"views_count":      "0",
+		"deletable":        strconv.FormatBool(deletable),
+		"client_encrypted": "true",
+		"allowed_ips":      allowedIps,
+	}, ttl)
+	if err != nil {
+		log.Errorf("SetClientPassword(config, ) Store err put : %+v", err)
 		return "", echo.NewHTTPError(http.StatusInternalServerError)
 	}
 
-	return storageKey.String() + TOKEN_SEPARATOR + encryptionKey, nil
+	return storageKey.String(), nil
 }
 
-func RetrievePassword(p *models.Password) *echo.HTTPError {
-	pool := NewPool()
-	c := pool.Get()
-	defer c.Close()
-	if Ping(c) == false {
-		return echo.NewHTTPError(http.StatusInternalServerError)
+/**
+ * Split a password key in storage key and decryption key.
+ * The key of client encrypted passwords is only the storage key, their decryption key is never sent to the server.
+ */
+func parsePasswordKey(config *Config, passwordKey string) (string, string, error) {
+	if !strings.Contains(passwordKey, config.TokenSeparator) {
+		if passwordKey == "" {
+			return "", "", errors.New("empty password key")
+		}
+		return passwordKey, "", nil
 	}
+	return ParseToken(config, passwordKey)
+}
 
-	storageKey, decryptionKey, err := ParseToken(p.PasswordKey)
+/**
+ * Without decryption key, only a client encrypted password can be read. Checked before counting a view.
+ */
+func checkClientEncrypted(config *Config, function string, storageKey string, clientIp string) *echo.HTTPError {
+	fields, _, err := config.Services.Store.Get(storageKey)
 	if err != nil {
+		return storeError(function, err)
+	}
+	if fields["client_encrypted"] != "true" {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
-	if decryptionKey == "" {
+	return CheckAllowedIps(fields["allowed_ips"], clientIp)
+}
+
+/**
+ * Convert a store error to the http error returned to the user
+ */
+func storeError(function string, err error) *echo.HTTPError {
+	if err == store.ErrNotFound {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
+	log.Error(function, "() Store err : ", err)
+	return echo.NewHTTPError(http.StatusInternalServerError)
+}
 
-	v, err := redis.Values(c.Do("HGETALL", REDIS_PREFIX+storageKey))
+func RetrievePassword(config *Config, p *models.Password) *echo.HTTPError {
+	storageKey, decryptionKey, err := parsePasswordKey(config, p.PasswordKey)
 	if err != nil {
-		log.Error("RetrievePassword() Redis err set : %+v\n", err)
-		return echo.NewHTTPError(http.StatusInternalServerError)
+		return echo.NewHTTPError(http.StatusNotFound)
 	}
 
-	err = redis.ScanStruct(v, p)
+	// Decrypted before counting the view, so a wrong passphrase doesn't consume it
+	var password string
+	if decryptionKey == "" {
+		if err := checkClientEncrypted(config, "RetrievePassword", storageKey, p.ClientIp); err != nil {
+			return err
+		}
+	} else {
+		var err2 *echo.HTTPError
+		password, err2 = peekPassword(config, storageKey, decryptionKey, p.Passphrase, p.ClientIp)
+		if err2 != nil {
+			return err2
+		}
+	}
+
+	fields, ttl, last, err := config.Services.Store.ConsumeView(storageKey)
 	if err != nil {
-		log.Error("RetrievePassword() Redis err scan struct : %+v\n", err)
+		return storeError("RetrievePassword", err)
+	}
+
+	err = fields.Scan(p)
+	if err != nil {
+		log.Errorf("RetrievePassword() err scan struct : %+v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
 
@@ -197,72 +380,90 @@ func RetrievePassword(p *models.Password) *echo.HTTPError {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
 
-	vc := p.ViewsCount + 1
-	vcLeft := p.Views - vc
+	// The view is already counted in ViewsCount
+	vcLeft := p.Views - p.ViewsCount
 	if vcLeft <= 0 {
 		vcLeft = 0
 	}
-
-	p.TTL, err = redis.Int(c.Do("TTL", REDIS_PREFIX+storageKey))
-	if err != nil {
-		log.Error("GetPassword() Redis err GET views count TTL : %+v\n", err)
-		return echo.NewHTTPError(http.StatusNotFound)
-	}
-
-	if vc >= p.Views {
-		_, err := c.Do("DEL", REDIS_PREFIX+storageKey)
-		if err != nil {
-			log.Error("GetPassword() Redis err DEL main key : %+v\n", err)
-			return echo.NewHTTPError(http.StatusNotFound)
-		}
-	} else {
-		_, err := c.Do("HSET", REDIS_PREFIX+storageKey, "views_count", vc)
-		if err != nil {
-			log.Error("GetPassword() Redis err SET views count : %+v\n", err)
-			return echo.NewHTTPError(http.StatusNotFound)
-		}
-	}
+	p.TTL = ttl
 	p.Views = vcLeft
+	ShareEvent(config, storageKey, EventRevealed, vcLeft, last)
 
-	password, err := Decrypt(p.Token, decryptionKey, p.TTL)
-	if err != nil {
-		log.Error("Error while decrypting password")
-		return echo.NewHTTPError(http.StatusNotFound)
+	// Sent as is, it's decrypted by the client with the key of the URL fragment
+	if p.ClientEncrypted {
+		p.Password = string(p.Token)
+		return nil
 	}
 	p.Password = password
 
 	return nil
 }
 
-/*
-https://gist.github.com/pohzipohzi/a202f8fb7cc30e33176dd97a9def5aac
-https://www.alexedwards.net/blog/working-with-redis
-*/
-func GetPassword(p *models.Password) *echo.HTTPError {
-	pool := NewPool()
-	c := pool.Get()
-	defer c.Close()
-	if Ping(c) == false {
-		return echo.NewHTTPError(http.StatusInternalServerError)
+/**
+ * Decrypt the password without counting a view. A wrong passphrase is an unauthorized error, a wrong key a not found.
+ * A client outside of the allowed networks is forbidden.
+ */
+func peekPassword(config *Config, storageKey string, decryptionKey string, passphrase string, clientIp string) (string, *echo.HTTPError) {
+	fields, _, err := config.Services.Store.Get(storageKey)
+	if err != nil {
+		return "", storeError("peekPassword", err)
 	}
 
-	storageKey, decryptionKey, err := ParseToken(p.PasswordKey)
+	p := new(models.Password)
+	err = fields.Scan(p)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound)
+		log.Errorf("peekPassword() err scan struct : %+v", err)
+		return "", echo.NewHTTPError(http.StatusInternalServerError)
 	}
-	if decryptionKey == "" {
+	if string(p.Token) == "" {
+		log.Error("Empty token")
+		return "", echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err := CheckAllowedIps(p.AllowedIps, clientIp); err != nil {
+		return "", err
+	}
+	if p.ClientEncrypted {
+		return "", nil
+	}
+
+	if !p.PassphraseProtected {
+		passphrase = ""
+	}
+	password, err := Decrypt(p.Token, decryptionKey, passphrase, p.Salt)
+	if err != nil {
+		if p.PassphraseProtected {
+			if failedAttempt(config, "peekPassword", storageKey, p.MaxAttempts) {
+				if err := config.Services.Store.Delete(storageKey); err != nil {
+					log.Errorf("peekPassword() Store err DEL : %+v", err)
+				}
+				ShareEvent(config, storageKey, EventDeleted, 0, true)
+				return "", echo.NewHTTPError(http.StatusUnauthorized, "Wrong passphrase, the secret was destroyed after too many attempts")
+			}
+			return "", echo.NewHTTPError(http.StatusUnauthorized, "Wrong passphrase")
+		}
+		log.Error("Error while decrypting password")
+		return "", echo.NewHTTPError(http.StatusNotFound)
+	}
+	return password, nil
+}
+
+/**
+ * Load the password information without counting a view
+ */
+func GetPassword(config *Config, p *models.Password) *echo.HTTPError {
+	storageKey, decryptionKey, err := parsePasswordKey(config, p.PasswordKey)
+	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
 
-	v, err := redis.Values(c.Do("HGETALL", REDIS_PREFIX+storageKey))
+	fields, ttl, err := config.Services.Store.Get(storageKey)
 	if err != nil {
-		log.Error("GetPassword() Redis err set : %+v\n", err)
-		return echo.NewHTTPError(http.StatusInternalServerError)
+		return storeError("GetPassword", err)
 	}
 
-	err = redis.ScanStruct(v, p)
+	err = fields.Scan(p)
 	if err != nil {
-		log.Error("GetPassword() Redis err scan struct : %+v\n", err)
+		log.Errorf("GetPassword() err scan struct : %+v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
 
@@ -270,6 +471,12 @@ func GetPassword(p *models.Password) *echo.HTTPError {
 		log.Error("Empty token")
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
+	if decryptionKey == "" && !p.ClientEncrypted {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err := CheckAllowedIps(p.AllowedIps, p.ClientIp); err != nil {
+		return err
+	}
 
 	vc := p.ViewsCount + 1
 	vcLeft := p.Views - vc
@@ -277,28 +484,17 @@ func GetPassword(p *models.Password) *echo.HTTPError {
 		vcLeft = 0
 	}
 
-	p.TTL, err = redis.Int(c.Do("TTL", REDIS_PREFIX+storageKey))
-	if err != nil {
-		log.Error("GetPassword() Redis err GET views count TTL : %+v\n", err)
-		return echo.NewHTTPError(http.StatusNotFound)
-	}
+	p.TTL = ttl
 	p.Views = vcLeft
 
 	return nil
 }
 
 /**
- * Remove a password from the redis store. If an error occur we return a not found
+ * Remove a password from the store. If an error occur we return a not found
  */
-func RemovePassword(p *models.Password) *echo.HTTPError {
-	pool := NewPool()
-	c := pool.Get()
-	defer c.Close()
-	if Ping(c) == false {
-		return echo.NewHTTPError(http.StatusInternalServerError)
-	}
-
-	storageKey, _, err := ParseToken(p.PasswordKey)
+func RemovePassword(config *Config, p *models.Password) *echo.HTTPError {
+	storageKey, _, err := parsePasswordKey(config, p.PasswordKey)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
@@ -306,15 +502,14 @@ func RemovePassword(p *models.Password) *echo.HTTPError {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
 
-	v, err := redis.Values(c.Do("HGETALL", REDIS_PREFIX+storageKey))
+	fields, _, err := config.Services.Store.Get(storageKey)
 	if err != nil {
-		log.Error("SetPassword() Redis err set : %+v\n", err)
-		return echo.NewHTTPError(http.StatusInternalServerError)
+		return storeError("RemovePassword", err)
 	}
 
-	err = redis.ScanStruct(v, p)
+	err = fields.Scan(p)
 	if err != nil {
-		log.Error("SetPassword() Redis err scan struct : %+v\n", err)
+		log.Errorf("RemovePassword() err scan struct : %+v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
 
@@ -322,61 +517,85 @@ func RemovePassword(p *models.Password) *echo.HTTPError {
 		return echo.NewHTTPError(http.StatusUnauthorized)
 	}
 
-	_, err = c.Do("DEL", REDIS_PREFIX+storageKey)
+	err = config.Services.Store.Delete(storageKey)
 	if err != nil {
-		log.Error("DeletePassword() Redis err : %+v\n", err)
+		log.Errorf("RemovePassword() Store err : %+v", err)
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
+	ShareEvent(config, storageKey, EventDeleted, 0, true)
 
 	return nil
 }
 
+// First wait before watching the store again after an error, doubled on each error up to watchBackoffMax
+const (
+	watchBackoff    = time.Second
+	watchBackoffMax = time.Minute
+)
+
 /**
- * Subscribe to redis and check when a key expire then clean the associated file
+ * Watch the store until ctx is done and clean the associated file when a file or upload key expire, the expiration
+ * of a share is sent to its webhooks. When the watch fails (e.g. the redis connection is lost) it's started again
+ * with an exponential backoff.
  */
-func CleanFileWatch() {
-	pool := NewPool()
-	c := pool.Get()
-	defer c.Close()
-	if Ping(c) == false {
-		log.Fatal("Can't open redis pool")
-	}
-
-	psc := redis.PubSubConn{c}
-	if err := psc.PSubscribe("__keyevent@*__:expired"); err != nil {
-		log.Fatal("Error from sub redis : %s", err)
-	}
-
+func CleanFileWatch(ctx context.Context, config *Config) {
+	wait := watchBackoff
 	for {
-		switch v := psc.Receive().(type) {
-		case redis.Message:
-			log.Debug("Message from redis %s %s \n", string(v.Data), v.Channel)
-			keyName := string(v.Data)
-			keyName = strings.Replace(keyName, REDIS_PREFIX+"file_", "", -1)
-			if strings.Contains(keyName, "_") {
-				return
-			}
-			CleanFile(keyName)
-
-		case redis.PMessage:
-			log.Debug("PMessage from redis %s\n", string(v.Data))
-			keyName := string(v.Data)
-			//if !strings.Contains(keyName, "file") { return }
-			CleanFile(keyName)
+		started := time.Now()
+		err := config.Services.Store.Watch(ctx, func(key string) {
+			expired(config, key)
+		})
+		if err == nil || ctx.Err() != nil {
+			return
+		}
 
-		case redis.Subscription:
-			log.Debug("Message from redis subscription ok : %s %s\n", v.Kind, v.Channel)
+		// A watch that worked for a while starts again from the first wait
+		if time.Since(started) > watchBackoffMax {
+			wait = watchBackoff
+		}
+		log.WithField("retry", wait.String()).Error("Error while watching expired keys : ", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
 		}
+		if wait *= 2; wait > watchBackoffMax {
+			wait = watchBackoffMax
+		}
+	}
+}
+
+/**
+ * Clean what an expired key leaves behind and notify the webhooks of the shares. The store also holds the API keys,
+ * the rate limits and the webhooks, only the file shares and the password shares (a bare uuid) are notified.
+ */
+func expired(config *Config, key string) {
+	if strings.HasPrefix(key, "file_") {
+		CleanFile(config, strings.TrimPrefix(key, "file_"))
+		ShareEvent(config, key, EventExpired, 0, true)
+	} else if strings.HasPrefix(key, "upload_") {
+		CleanUpload(config, strings.TrimPrefix(key, "upload_"))
+	} else if passwordKey(key) {
+		ShareEvent(config, key, EventExpired, 0, true)
 	}
 }
 
-func CleanFile(fileName string) {
-	log.Debug("CleanFile fileName : %s\n", fileName)
-	filePathName := FILEFOLDER + "/" + fileName + ".zip"
+/**
+ * True when key is the storage key of a password share, the uuid made by SetPassword and SetClientPassword
+ */
+func passwordKey(key string) bool {
+	if len(key) != 36 {
+		return false
+	}
+	_, err := uuid.Parse(key)
+	return err == nil
+}
 
-	err := os.Remove(filePathName)
+func CleanFile(config *Config, fileName string) {
+	log.Debugf("CleanFile fileName : %s", fileName)
+	err := config.Services.Blobs.Remove(fileName + ".zip")
 	if err != nil {
-		log.Error("Delete file remove error : %+v\n", err)
+		log.Errorf("Delete file remove error : %+v", err)
 	}
 }
 
@@ -392,59 +611,66 @@ func RandomSequence(n int) string {
 }
 
 /**
- * Encrypt and store the password for the specified lifetime.
- * Returns a token comprised of the key where the encrypted password is stored, and the decryption key.
+ * Generate the token of a new file share, comprised of the storage key and the encryption key.
+ * The archive is written with it before the share is saved with SetFile.
+ * @return {string} token
+ */
+func NewFileToken(config *Config) (string, error) {
+	var k fernet.Key
+	err := k.Generate()
+	if err != nil {
+		log.Errorf("NewFileToken() Generate err : %+v", err)
+		return "", err
+	}
+	return uuid.New().String() + config.TokenSeparator + k.Encode(), nil
+}
+
+/**
+ * Encrypt and store the password of the file share token for the specified lifetime.
+ * @param {string} token from NewFileToken
  * @param {string} password
  * @param {number} ttl
  * @param {number} views
  * @param {boolean} deletable
- * @return {string} token
+ * @param {number} maxAttempts wrong passwords before the file is destroyed, 0 for OKURU_MAX_FAILED_ATTEMPTS
+ * @param {string} allowedIps networks the file can be downloaded from, from ParseAllowedIps (optional)
  */
-func SetFile(password string, ttl int, views int, deletable, provided bool, providedKey string) (string, *echo.HTTPError) { //done
-	pool := NewPool()
-	c := pool.Get()
-	defer c.Close()
-	if Ping(c) == false {
-		return "", echo.NewHTTPError(http.StatusInternalServerError)
+func SetFile(config *Config, token string, password string, ttl int, views int, deletable, provided bool, providedKey string, maxAttempts int, allowedIps string) *echo.HTTPError {
+	storageKey, encryptionKey, err := ParseToken(config, token)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
-
-	storageKey := uuid.New()
-	encryptedPassword, encryptionKey, err := Encrypt(password)
+	k, err := fernet.DecodeKey(encryptionKey)
 	if err != nil {
-		return "", echo.NewHTTPError(http.StatusInternalServerError)
+		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
-
-	_, err = c.Do("HMSET", REDIS_PREFIX+"file_"+storageKey.String(),
-		"token", encryptedPassword,
-		"views", views,
-		"views_count", 0,
-		"deletable", deletable,
-		"provided", provided,
-		"provided_key", providedKey)
-
+	encryptedPassword, err := fernet.EncryptAndSign([]byte(password), k)
 	if err != nil {
-		log.Error("SetPassword() Redis err set : %+v\n", err)
-		return "", echo.NewHTTPError(http.StatusInternalServerError)
+		log.Errorf("SetFile() EncryptAndSign err : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
 
-	_, err = c.Do("EXPIRE", REDIS_PREFIX+"file_"+storageKey.String(), ttl)
+	err = config.Services.Store.Put("file_"+storageKey, store.Fields{
+		"token":           string(encryptedPassword),
+		"views":           strconv.Itoa(views),
+		"views_count":     "0",
+		"deletable":       strconv.FormatBool(deletable),
+		"provided":        strconv.FormatBool(provided),
+		"provided_key":    providedKey,
+		"max_attempts":    strconv.Itoa(GetMaxAttempts(config, maxAttempts)),
+		"failed_attempts": "0",
+		"allowed_ips":     allowedIps,
+	}, ttl)
 	if err != nil {
-		log.Error("SetPassword() Redis err expire : %+v\n", err)
-		return "", echo.NewHTTPError(http.StatusInternalServerError)
+		log.Errorf("SetFile() Store err put : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
 
-	return storageKey.String() + TOKEN_SEPARATOR + encryptionKey, nil
+	return nil
 }
 
-func RetrieveFilePassword(f *models.File) *echo.HTTPError {
-	pool := NewPool()
-	c := pool.Get()
-	defer c.Close()
-	if Ping(c) == false {
-		return echo.NewHTTPError(http.StatusInternalServerError)
-	}
-
-	storageKey, decryptionKey, err := ParseToken(f.FileKey)
+func RetrieveFilePassword(config *Config, f *models.File) *echo.HTTPError {
+	storageKey, decryptionKey, err := ParseToken(config, f.FileKey)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
@@ -452,15 +678,14 @@ func RetrieveFilePassword(f *models.File) *echo.HTTPError {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
 
-	v, err := redis.Values(c.Do("HGETALL", REDIS_PREFIX+"file_"+storageKey))
+	fields, _, err := config.Services.Store.Get("file_" + storageKey)
 	if err != nil {
-		log.Error("SetPassword() Redis err set : %+v\n", err)
-		return echo.NewHTTPError(http.StatusInternalServerError)
+		return storeError("RetrieveFilePassword", err)
 	}
 
-	err = redis.ScanStruct(v, f)
+	err = fields.Scan(f)
 	if err != nil {
-		log.Error("SetPassword() Redis err scan struct : %+v\n", err)
+		log.Errorf("RetrieveFilePassword() err scan struct : %+v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
 
@@ -468,8 +693,11 @@ func RetrieveFilePassword(f *models.File) *echo.HTTPError {
 		log.Error("Empty token")
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
+	if err := CheckAllowedIps(f.AllowedIps, f.ClientIp); err != nil {
+		return err
+	}
 
-	password, err := Decrypt(f.Token, decryptionKey, f.TTL)
+	password, err := Decrypt(f.Token, decryptionKey, "", "")
 	if err != nil {
 		log.Error("Error while decrypting password")
 		return echo.NewHTTPError(http.StatusNotFound)
@@ -477,106 +705,38 @@ func RetrieveFilePassword(f *models.File) *echo.HTTPError {
 	f.Password = password
 
 	if f.ViewsCount >= f.Views {
-		_, err := c.Do("DEL", REDIS_PREFIX+"file_"+storageKey)
+		err := config.Services.Store.Delete("file_" + storageKey)
 		if err != nil {
-			log.Error("SetFile() Redis err DEL main key : %+v\n", err)
+			log.Errorf("RetrieveFilePassword() Store err DEL main key : %+v", err)
 			return echo.NewHTTPError(http.StatusNotFound)
 		}
-		CleanFile(storageKey)
+		CleanFile(config, storageKey)
 	}
 
 	return nil
 }
 
-func GetFile(f *models.File) *echo.HTTPError {
-	pool := NewPool()
-	c := pool.Get()
-	defer c.Close()
-
-	storageKey, decryptionKey, err := ParseToken(f.FileKey)
-	if err != nil {	
-		return echo.NewHTTPError(http.StatusNotFound)
-	}
-	if decryptionKey == "" {
-		return echo.NewHTTPError(http.StatusNotFound)
-	}
-
-	var err2 *echo.HTTPError
-	err2 = RetrieveFilePassword(f)
-	if err2 != nil {
-		return err2
-	}
-
-	vc := f.ViewsCount + 1
-	vcLeft := f.Views - vc
-	if vcLeft <= 0 {
-		vcLeft = 0
-	}
-
-	f.TTL, err = redis.Int(c.Do("TTL", REDIS_PREFIX+"file_"+storageKey))
-	if err != nil {
-		log.Error("GetFile() Redis err GET views count TTL : %+v\n", err)
-		return echo.NewHTTPError(http.StatusNotFound)
-	}
-
-	if f.TTL == -2 {
-		log.Error("GetFile() Redis err TTL : %+v\n", err)
-		return echo.NewHTTPError(http.StatusNotFound)
-	}
-
-	f.PasswordProvided, err = redis.Bool(c.Do("HGET", REDIS_PREFIX+"file_"+storageKey, "provided"))
-	if err != nil {
-		log.Error("GetFile() Redis err GET file password provided value : %+v\n", err)
-		return echo.NewHTTPError(http.StatusNotFound)
+/**
+ * Keep the password provided with a file in sync with the file views, it's removed with the file.
+ */
+func syncProvidedPassword(config *Config, f *models.File, last bool) {
+	if !f.PasswordProvided || f.PasswordProvidedKey == "" {
+		return
 	}
 
-	if vc >= f.Views {
-		_, err := c.Do("DEL", REDIS_PREFIX+"file_"+storageKey)
-		if err != nil {
-			log.Error("GetFile() Redis err DEL main key : %+v\n", err)
-			return echo.NewHTTPError(http.StatusNotFound)
-		}
-		if f.PasswordProvided {
-			_, err := c.Do("DEL", REDIS_PREFIX+f.PasswordProvidedKey)
-			if err != nil {
-				log.Error("GetFile() Redis err DEL password provided key : %+v\n", err)
-				return echo.NewHTTPError(http.StatusNotFound)
-			}
-		}
-		CleanFile(storageKey)
+	var err error
+	if last {
+		err = config.Services.Store.Delete(f.PasswordProvidedKey)
 	} else {
-		_, err := c.Do("HSET", REDIS_PREFIX+"file_"+storageKey, "views_count", vc)
-		if err != nil {
-			log.Error("GetFile() Redis err SET views count : %+v\n", err)
-			return echo.NewHTTPError(http.StatusNotFound)
-		}
-		if f.PasswordProvided {
-			_, err := c.Do("HSET", REDIS_PREFIX+f.PasswordProvidedKey, "views_count", vc)
-			if err != nil {
-				log.Error("GetFile() Redis err SET views count password provided error : %+v\n", err)
-				return echo.NewHTTPError(http.StatusNotFound)
-			}
-		}
+		err = config.Services.Store.Update(f.PasswordProvidedKey, store.Fields{"views_count": strconv.Itoa(f.ViewsCount)})
 	}
-	f.Views = vcLeft
-
-	password, err := Decrypt(f.Token, decryptionKey, f.TTL)
-	if err != nil {
-		log.Error("Error while decrypting password")
-		return echo.NewHTTPError(http.StatusNotFound)
+	if err != nil && err != store.ErrNotFound {
+		log.Errorf("syncProvidedPassword(config, ) Store err : %+v", err)
 	}
-	f.Password = password
-
-	return nil
 }
 
-func RemoveFile(f *models.File) *echo.HTTPError {
-	pool := NewPool()
-	c := pool.Get()
-	defer c.Close()
-	println("RemoveFile")
-
-	storageKey, _, err := ParseToken(f.FileKey)
+func RemoveFile(config *Config, f *models.File) *echo.HTTPError {
+	storageKey, _, err := ParseToken(config, f.FileKey)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
@@ -584,15 +744,14 @@ func RemoveFile(f *models.File) *echo.HTTPError {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
 
-	v, err := redis.Values(c.Do("HGETALL", REDIS_PREFIX+"file_"+storageKey))
+	fields, _, err := config.Services.Store.Get("file_" + storageKey)
 	if err != nil {
-		log.Error("SetPassword() Redis err set : %+v\n", err)
-		return echo.NewHTTPError(http.StatusInternalServerError)
+		return storeError("RemoveFile", err)
 	}
 
-	err = redis.ScanStruct(v, f)
+	err = fields.Scan(f)
 	if err != nil {
-		log.Error("SetPassword() Redis err scan struct : %+v\n", err)
+		log.Errorf("RemoveFile() err scan struct : %+v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
 
@@ -600,21 +759,15 @@ func RemoveFile(f *models.File) *echo.HTTPError {
 		return echo.NewHTTPError(http.StatusUnauthorized)
 	}
 
-	_, err = c.Do("DEL", REDIS_PREFIX+"file_"+storageKey)
+	err = config.Services.Store.Delete("file_" + storageKey)
 	if err != nil {
-		log.Error("DeletePassword() Redis err : %+v\n", err)
+		log.Errorf("RemoveFile() Store err : %+v", err)
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
+	syncProvidedPassword(config, f, true)
+	ShareEvent(config, "file_"+storageKey, EventDeleted, 0, true)
 
-	if f.PasswordProvided == true {
-		_, err = c.Do("DEL", REDIS_PREFIX+f.PasswordProvidedKey)
-		if err != nil {
-			log.Error("DeletePassword() Redis err : %+v\n", err)
-			return echo.NewHTTPError(http.StatusNotFound)
-		}
-	}
-
-	CleanFile(storageKey)
+	CleanFile(config, storageKey)
 
 	return nil
 }
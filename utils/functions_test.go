@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"crypto/tls"
+	"github.com/labstack/echo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetBaseUrl(t *testing.T) {
+	tests := []struct {
+		name       string
+		configure  func(config *Config)
+		remoteAddr string
+		tls        bool
+		proto      string
+		want       string
+	}{
+		{name: "http behind a proxy", remoteAddr: "192.0.2.1:1234", want: "https://okuru.example"},
+		{name: "https", remoteAddr: "192.0.2.1:1234", tls: true, want: "https://okuru.example"},
+		{name: "no ssl", configure: func(config *Config) { config.NoSsl = true }, remoteAddr: "192.0.2.1:1234", want: "http://okuru.example"},
+		{
+			name:       "no ssl untrusted proxy",
+			configure:  func(config *Config) { config.NoSsl = true },
+			remoteAddr: "192.0.2.1:1234",
+			proto:      "https",
+			want:       "http://okuru.example",
+		},
+		{
+			name:       "untrusted proxy",
+			configure:  func(config *Config) { config.TrustedProxies = []string{"10.0.0.0/8"} },
+			remoteAddr: "192.0.2.1:1234",
+			proto:      "https",
+			want:       "http://okuru.example",
+		},
+		{
+			name:       "trusted proxy",
+			configure:  func(config *Config) { config.TrustedProxies = []string{"10.0.0.0/8"} },
+			remoteAddr: "10.0.0.1:1234",
+			proto:      "https",
+			want:       "https://okuru.example",
+		},
+		{
+			name:       "trusted proxy in http",
+			configure:  func(config *Config) { config.TrustedProxies = []string{"10.0.0.0/8"} },
+			remoteAddr: "10.0.0.1:1234",
+			want:       "http://okuru.example",
+		},
+		{
+			name:       "base url",
+			configure:  func(config *Config) { config.BaseUrl = "https://example.com/okuru/" },
+			remoteAddr: "192.0.2.1:1234",
+			want:       "https://example.com/okuru",
+		},
+	}
+	for _, test := range tests {
+		config := DefaultConfig()
+		if test.configure != nil {
+			test.configure(config)
+		}
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Host = "okuru.example"
+		request.RemoteAddr = test.remoteAddr
+		if test.tls {
+			request.TLS = &tls.ConnectionState{}
+		}
+		if test.proto != "" {
+			request.Header.Set(echo.HeaderXForwardedProto, test.proto)
+		}
+		context := echo.New().NewContext(request, httptest.NewRecorder())
+		SetConfig(context, config)
+
+		if got := GetBaseUrl(context); got != test.want {
+			t.Errorf("%s: GetBaseUrl = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
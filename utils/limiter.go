@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"math"
+	"sync"
+	"time"
+)
+
+/**
+ * Limiter keeps a token bucket per key. A bucket holds up to rate tokens and is refilled with rate tokens per minute.
+ */
+type Limiter interface {
+	// Take removes a token from the bucket of key, if it's empty it returns false and the time until the next token
+	Take(key string, rate int) (bool, time.Duration, error)
+	Close() error
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+/**
+ * Limiter kept in the process memory, each instance counts on its own.
+ */
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]*bucket), swept: time.Now()}
+}
+
+func (m *MemoryLimiter) Take(key string, rate int) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rate), last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(rate), b.tokens+now.Sub(b.last).Minutes()*float64(rate))
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / float64(rate) * float64(time.Minute)), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+func (m *MemoryLimiter) Close() error {
+	return nil
+}
+
+// A bucket untouched for a minute is full again, it can be forgotten. Must be called with the lock held
+func (m *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	for key, b := range m.buckets {
+		if now.Sub(b.last) >= time.Minute {
+			delete(m.buckets, key)
+		}
+	}
+	m.swept = now
+}
+
+/*
+ * Refill and take a token in a single step so several Okuru instances share the same buckets.
+ * Returns 1 and 0 if a token was taken, otherwise 0 and the milliseconds before the next token.
+ */
+var takeScript = redis.NewScript(1, `
+local rate = tonumber(ARGV[1])
+local now = tonumber(ARGV[2])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(bucket[1]) or rate
+local last = tonumber(bucket[2]) or now
+tokens = math.min(rate, tokens + math.max(0, now - last) * rate / 60000)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * 60000 / rate)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+redis.call('PEXPIRE', KEYS[1], 60000)
+return {allowed, wait}
+`)
+
+/**
+ * Limiter kept in Redis, for several Okuru instances behind a load balancer.
+ */
+type RedisLimiter struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+func NewRedisLimiter(pool *redis.Pool, prefix string) *RedisLimiter {
+	return &RedisLimiter{pool: pool, prefix: prefix}
+}
+
+// The pool belongs to the store, it's closed with it
+func (r *RedisLimiter) Close() error {
+	return nil
+}
+
+func (r *RedisLimiter) Take(key string, rate int) (bool, time.Duration, error) {
+	c := r.pool.Get()
+	defer c.Close()
+
+	reply, err := redis.Ints(takeScript.Do(c, r.prefix+key, rate, time.Now().UnixNano()/int64(time.Millisecond)))
+	if err != nil {
+		return false, 0, err
+	}
+	return reply[0] == 1, time.Duration(reply[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/garyburd/redigo/redis"
+	"testing"
+	"time"
+)
+
+func newTestRedisLimiter(t *testing.T) (*RedisLimiter, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	pool := &redis.Pool{
+		MaxIdle: 10,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", mr.Addr())
+		},
+	}
+	t.Cleanup(func() { pool.Close() })
+	return NewRedisLimiter(pool, "okuru_ratelimit_"), mr
+}
+
+/**
+ * Both limiters must give rate tokens, then refuse with the time until the next one, keeping each key on its own.
+ */
+func TestLimiters(t *testing.T) {
+	redisLimiter, _ := newTestRedisLimiter(t)
+	limiters := map[string]Limiter{
+		"memory": NewMemoryLimiter(),
+		"redis":  redisLimiter,
+	}
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			const rate = 3
+			for i := 0; i < rate; i++ {
+				ok, wait, err := limiter.Take("reveal_192.0.2.1", rate)
+				if err != nil || !ok || wait != 0 {
+					t.Fatalf("Take %d = %v, %v, %v, want a token", i, ok, wait, err)
+				}
+			}
+
+			ok, wait, err := limiter.Take("reveal_192.0.2.1", rate)
+			if err != nil || ok {
+				t.Fatalf("Take over the rate = %v, %v, want no token", ok, err)
+			}
+			// A token comes back every 20 seconds at 3 per minute
+			if wait <= 0 || wait > 20*time.Second {
+				t.Errorf("Take over the rate wait = %v, want at most 20s", wait)
+			}
+
+			if ok, _, err := limiter.Take("reveal_192.0.2.2", rate); err != nil || !ok {
+				t.Errorf("Take of another key = %v, %v, want a token", ok, err)
+			}
+		})
+	}
+}
+
+func TestMemoryLimiterRefill(t *testing.T) {
+	m := NewMemoryLimiter()
+	for i := 0; i < 2; i++ {
+		m.Take("a", 2)
+	}
+	if ok, _, _ := m.Take("a", 2); ok {
+		t.Fatal("Take of an empty bucket gave a token")
+	}
+
+	// Half a minute at 2 per minute refills a token, and the bucket never holds more than rate
+	m.buckets["a"].last = m.buckets["a"].last.Add(-30 * time.Second)
+	if ok, _, _ := m.Take("a", 2); !ok {
+		t.Fatal("Take after 30 seconds didn't give the refilled token")
+	}
+	if ok, _, _ := m.Take("a", 2); ok {
+		t.Fatal("Take gave a second token after 30 seconds")
+	}
+
+	m.buckets["a"].last = m.buckets["a"].last.Add(-time.Hour)
+	if m.Take("a", 2); m.buckets["a"].tokens != 1 {
+		t.Errorf("tokens = %v after an hour and a take, want 1", m.buckets["a"].tokens)
+	}
+}
+
+func TestTakeScript(t *testing.T) {
+	r, mr := newTestRedisLimiter(t)
+	c := r.pool.Get()
+	defer c.Close()
+
+	take := func(now int64) []int {
+		reply, err := redis.Ints(takeScript.Do(c, "okuru_ratelimit_a", 2, now))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	const start = 1000000
+	for i := 0; i < 2; i++ {
+		if reply := take(start); reply[0] != 1 || reply[1] != 0 {
+			t.Fatalf("take %d = %v, want [1 0]", i, reply)
+		}
+	}
+	if reply := take(start); reply[0] != 0 || reply[1] != 30000 {
+		t.Fatalf("take of an empty bucket = %v, want [0 30000]", reply)
+	}
+	if reply := take(start + 15000); reply[0] != 0 || reply[1] != 15000 {
+		t.Fatalf("take 15s later = %v, want [0 15000]", reply)
+	}
+	if reply := take(start + 30000); reply[0] != 1 {
+		t.Fatalf("take 30s later = %v, want a token", reply)
+	}
+
+	// A clock behind the last take must not remove tokens
+	if reply := take(start); reply[0] != 0 || reply[1] != 30000 {
+		t.Fatalf("take with an older clock = %v, want [0 30000]", reply)
+	}
+
+	if ttl := mr.TTL("okuru_ratelimit_a"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("bucket ttl = %v, want at most a minute", ttl)
+	}
+}
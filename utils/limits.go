@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"github.com/labstack/echo"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// Durations offered by the forms, only the ones between the min and the max TTL are kept
+var ttlSteps = []int{
+	300, 900, 1800,
+	3600, 2 * 3600, 3 * 3600, 4 * 3600, 5 * 3600, 6 * 3600, 8 * 3600, 12 * 3600, 18 * 3600,
+	86400, 2 * 86400, 3 * 86400, 4 * 86400, 5 * 86400, 6 * 86400, 7 * 86400, 14 * 86400, 30 * 86400, 60 * 86400, 90 * 86400,
+}
+
+/**
+ * Bounds and defaults of the TTL (seconds) and the views of the passwords or the files
+ */
+type Limits struct {
+	TtlMin       int
+	TtlMax       int
+	TtlDefault   int
+	ViewsMin     int
+	ViewsMax     int
+	ViewsDefault int
+}
+
+/**
+ * A duration of the TTL dropdown of the forms
+ */
+type TtlOption struct {
+	Seconds int
+	Text    string
+}
+
+/**
+ * Check the TTL of a new share, 0 gives the default
+ */
+func (l Limits) CheckTtl(ttl int) (int, *echo.HTTPError) {
+	if ttl == 0 {
+		return l.TtlDefault, nil
+	}
+	if ttl < l.TtlMin || ttl > l.TtlMax {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "TTL out of range (min "+strconv.Itoa(l.TtlMin)+", max "+strconv.Itoa(l.TtlMax)+" seconds)")
+	}
+	return ttl, nil
+}
+
+/**
+ * Check the views of a new share, 0 gives the default
+ */
+func (l Limits) CheckViews(views int) (int, *echo.HTTPError) {
+	if views == 0 {
+		return l.ViewsDefault, nil
+	}
+	if views < l.ViewsMin || views > l.ViewsMax {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Views out of range (min "+strconv.Itoa(l.ViewsMin)+", max "+strconv.Itoa(l.ViewsMax)+")")
+	}
+	return views, nil
+}
+
+/**
+ * Parse and check the TTL of a form or metadata value, empty gives the default
+ */
+func (l Limits) ParseTtl(value string) (int, *echo.HTTPError) {
+	if value == "" {
+		return l.TtlDefault, nil
+	}
+	ttl, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "TTL must be a number of seconds")
+	}
+	return l.CheckTtl(ttl)
+}
+
+/**
+ * Parse and check the views of a form or metadata value, empty gives the default
+ */
+func (l Limits) ParseViews(value string) (int, *echo.HTTPError) {
+	if value == "" {
+		return l.ViewsDefault, nil
+	}
+	views, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Views must be a number")
+	}
+	return l.CheckViews(views)
+}
+
+/**
+ * Durations of the TTL dropdown, always with the min, the max and the default
+ */
+func (l Limits) TtlOptions() []TtlOption {
+	durations := []int{l.TtlMin, l.TtlDefault, l.TtlMax}
+	for _, step := range ttlSteps {
+		if step > l.TtlMin && step < l.TtlMax {
+			durations = append(durations, step)
+		}
+	}
+	sort.Ints(durations)
+
+	var options []TtlOption
+	for i, seconds := range durations {
+		if i > 0 && seconds == durations[i-1] {
+			continue
+		}
+		options = append(options, TtlOption{Seconds: seconds, Text: DurationText(seconds)})
+	}
+	return options
+}
+
+/**
+ * Help of the ttl and views parameters of the API
+ */
+func (l Limits) Help() string {
+	return `ttl: (optional) number seconds, min: ` + strconv.Itoa(l.TtlMin) + `, max: ` + strconv.Itoa(l.TtlMax) + `, default: ` + strconv.Itoa(l.TtlDefault) + ` (` + DurationText(l.TtlDefault) + `)
+views: (optional) number between ` + strconv.Itoa(l.ViewsMin) + ` and ` + strconv.Itoa(l.ViewsMax) + `, default: ` + strconv.Itoa(l.ViewsDefault)
+}
+
+/**
+ * Duration in the biggest unit that divides it, e.g. "1 hour" or "90 minutes"
+ */
+func DurationText(seconds int) string {
+	units := []struct {
+		seconds int
+		name    string
+	}{{86400, "day"}, {3600, "hour"}, {60, "minute"}, {1, "second"}}
+	for _, unit := range units {
+		if seconds%unit.seconds == 0 {
+			count := seconds / unit.seconds
+			if count == 1 {
+				return "1 " + unit.name
+			}
+			return strconv.Itoa(count) + " " + unit.name + "s"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"github.com/labstack/echo"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Max number of networks in the allow-list of a share
+const maxAllowedIps = 32
+
+/**
+ * Parse an IP or a CIDR range, a single IP is a /32 (or /128 for IPv6) range
+ */
+func ParseNetwork(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		return network, err
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: value}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+/**
+ * Parse the allow-list given at the creation of a share, IPs or CIDR ranges separated by commas or spaces.
+ * Return the list as stored with the share, empty when the share can be opened from anywhere.
+ */
+func ParseAllowedIps(value string) (string, *echo.HTTPError) {
+	var networks []string
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t' }) {
+		network, err := ParseNetwork(entry)
+		if err != nil {
+			return "", echo.NewHTTPError(http.StatusBadRequest, "Invalid IP or CIDR range in the allowed networks : "+entry)
+		}
+		networks = append(networks, network.String())
+	}
+	if len(networks) > maxAllowedIps {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "Too many allowed networks (max 32)")
+	}
+	return strings.Join(networks, ","), nil
+}
+
+/**
+ * Check the client IP against the allow-list of a share, an empty list allows everyone.
+ */
+func CheckAllowedIps(allowedIps string, clientIp string) *echo.HTTPError {
+	if allowedIps == "" {
+		return nil
+	}
+
+	ip := net.ParseIP(clientIp)
+	if ip != nil {
+		for _, entry := range strings.Split(allowedIps, ",") {
+			_, network, err := net.ParseCIDR(entry)
+			if err == nil && network.Contains(ip) {
+				return nil
+			}
+		}
+	}
+	return echo.NewHTTPError(http.StatusForbidden, "This share can't be opened from your network")
+}
+
+/**
+ * Check the allow-list of the share stored under key, before counting a view
+ */
+func checkShareNetwork(config *Config, function string, key string, clientIp string) *echo.HTTPError {
+	fields, _, err := config.Services.Store.Get(key)
+	if err != nil {
+		return storeError(function, err)
+	}
+	return CheckAllowedIps(fields["allowed_ips"], clientIp)
+}
+
+/**
+ * Address the request comes from, its IP is nil when the address isn't an IP
+ */
+func remoteAddr(request *http.Request) (string, net.IP) {
+	remote, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		remote = request.RemoteAddr
+	}
+	return remote, net.ParseIP(remote)
+}
+
+/**
+ * IP of the client. X-Forwarded-For and X-Real-IP are only read when the request comes from one of
+ * OKURU_TRUSTED_PROXIES, otherwise anyone could pick the IP of their choice.
+ */
+func ClientIP(context echo.Context) string {
+	request := context.Request()
+	config := GetConfig(context)
+	remote, ip := remoteAddr(request)
+	if ip == nil || !config.trustedProxy(ip) {
+		return remote
+	}
+
+	// Each proxy appends the address it received the request from, the client is the last one that isn't a trusted proxy
+	forwarded := strings.Split(strings.Join(request.Header.Values(echo.HeaderXForwardedFor), ","), ",")
+	hops := 0
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		hops++
+		if !config.trustedProxy(hop) {
+			return hop.String()
+		}
+	}
+	if hops == 0 {
+		if realIp := net.ParseIP(request.Header.Get(echo.HeaderXRealIP)); realIp != nil {
+			return realIp.String()
+		}
+	}
+	return ip.String()
+}
+
+/**
+ * Scheme and host the client reached Okuru with. X-Forwarded-Proto and X-Forwarded-Host are only read when the request
+ * comes from one of OKURU_TRUSTED_PROXIES. With several proxies the first value is the one the client used.
+ */
+func forwardedOrigin(context echo.Context) (string, string) {
+	request := context.Request()
+	scheme, host := "http", request.Host
+	if context.IsTLS() {
+		scheme = "https"
+	}
+	if _, ip := remoteAddr(request); ip == nil || !GetConfig(context).trustedProxy(ip) {
+		return scheme, host
+	}
+
+	if proto := firstForwarded(request.Header.Get(echo.HeaderXForwardedProto)); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	if forwardedHost := firstForwarded(request.Header.Get("X-Forwarded-Host")); forwardedHost != "" && !strings.ContainsAny(forwardedHost, "/\\@?# ") {
+		host = forwardedHost
+	}
+	return scheme, host
+}
+
+/**
+ * True when the client reached Okuru in https, served over TLS or through a proxy of OKURU_TRUSTED_PROXIES saying so
+ */
+func IsHttps(context echo.Context) bool {
+	scheme, _ := forwardedOrigin(context)
+	return scheme == "https"
+}
+
+func firstForwarded(value string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(value, ",")[0]))
+}
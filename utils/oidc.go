@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/eraffaelli/Okuru/models"
+	"github.com/eraffaelli/Okuru/store"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"strings"
+	"time"
+)
+
+// Time given to the user to log in on the provider
+const loginDuration = 10 * time.Minute
+
+type OIDCAuth struct {
+	verifier *oidc.IDTokenVerifier
+	config   oauth2.Config
+	// Callback url registered on the provider, made from the request when empty
+	redirectUrl string
+}
+
+/**
+ * State of a login kept in a signed cookie between the redirection to the provider and the callback
+ */
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next"`
+	Expire   int64  `json:"exp"`
+}
+
+/**
+ * Discover the provider of OKURU_OIDC_ISSUER, nil if it isn't set
+ */
+func NewOIDC(ctx context.Context, config *Config) (*OIDCAuth, error) {
+	if config.OidcIssuer == "" {
+		return nil, nil
+	}
+	if config.OidcClientId == "" {
+		return nil, errors.New("OKURU_OIDC_CLIENT_ID is needed with OKURU_OIDC_ISSUER")
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.OidcIssuer)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCAuth{
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.OidcClientId}),
+		config: oauth2.Config{
+			ClientID:     config.OidcClientId,
+			ClientSecret: config.OidcClientSecret,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       config.OidcScopes,
+		},
+		redirectUrl: config.OidcRedirectUrl,
+	}, nil
+}
+
+func (a *OIDCAuth) redirectURL(context echo.Context) string {
+	if a.redirectUrl != "" {
+		return a.redirectUrl
+	}
+	return GetBaseUrl(context) + "/auth/callback"
+}
+
+/**
+ * Return the url of the provider login page, next is where the user goes back once logged in
+ */
+func (a *OIDCAuth) Login(context echo.Context, next string) (string, error) {
+	state := loginState{
+		State:    RandomToken(),
+		Nonce:    RandomToken(),
+		Verifier: oauth2.GenerateVerifier(),
+		Next:     next,
+		Expire:   time.Now().Add(loginDuration).Unix(),
+	}
+	value, err := signCookie(GetConfig(context), state)
+	if err != nil {
+		return "", err
+	}
+	context.SetCookie(newCookie(GetConfig(context), loginCookie, value, int(loginDuration.Seconds())))
+
+	config := a.config
+	config.RedirectURL = a.redirectURL(context)
+	return config.AuthCodeURL(state.State, oidc.Nonce(state.Nonce), oauth2.S256ChallengeOption(state.Verifier)), nil
+}
+
+/**
+ * Finish the login when the provider redirects to the callback, return the user and where to send them back
+ */
+func (a *OIDCAuth) Callback(context echo.Context) (*models.User, string, error) {
+	cookie, err := context.Cookie(loginCookie)
+	if err != nil {
+		return nil, "", errors.New("no login in progress")
+	}
+	context.SetCookie(newCookie(GetConfig(context), loginCookie, "", -1))
+	state := new(loginState)
+	if err := verifyCookie(GetConfig(context), cookie.Value, state); err != nil {
+		return nil, "", err
+	}
+	if context.QueryParam("state") != state.State {
+		return nil, "", errors.New("state mismatch")
+	}
+	if description := context.QueryParam("error"); description != "" {
+		return nil, "", errors.New("provider error : " + description)
+	}
+
+	config := a.config
+	config.RedirectURL = a.redirectURL(context)
+	ctx := context.Request().Context()
+	token, err := config.Exchange(ctx, context.QueryParam("code"), oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		return nil, "", err
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", errors.New("no id_token in the token response")
+	}
+	idToken, err := a.verifier.Verify(ctx, rawIdToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if idToken.Nonce != state.Nonce {
+		return nil, "", errors.New("nonce mismatch")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, "", err
+	}
+	u := &models.User{Subject: idToken.Subject, Email: claims.Email, Name: claims.Name}
+	if u.Name == "" {
+		u.Name = claims.PreferredUsername
+	}
+	return u, state.Next, nil
+}
+
+/**
+ * Identity of a user as stored with the shares they create
+ */
+func Identity(u *models.User) string {
+	if u.Email != "" {
+		return u.Email
+	}
+	return u.Subject
+}
+
+/**
+ * Keep the identity of the user who created the share stored under key, for audit
+ */
+func SetCreator(config *Config, key string, u *models.User) {
+	if err := config.Services.Store.Update(key, store.Fields{"creator": Identity(u)}); err != nil {
+		log.Errorf("SetCreator(config, ) Store err update : %+v", err)
+		return
+	}
+	log.WithField("creator", Identity(u)).WithField("sub", u.Subject).WithField("share", key).Info("Share created")
+}
+
+/**
+ * Only keep local paths, so the login can't be used to redirect to another site
+ */
+func LocalPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
@@ -0,0 +1,21 @@
+package utils
+
+import "testing"
+
+func TestLocalPath(t *testing.T) {
+	tests := map[string]string{
+		"":                     "/",
+		"/":                    "/",
+		"/file":                "/file",
+		"/password/abc?x=1":    "/password/abc?x=1",
+		"//evil.example":       "/",
+		"/\\evil.example":      "/",
+		"https://evil.example": "/",
+		"evil.example":         "/",
+	}
+	for next, want := range tests {
+		if got := LocalPath(next); got != want {
+			t.Errorf("LocalPath(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
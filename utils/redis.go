@@ -1,62 +1,264 @@
 package utils
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"github.com/garyburd/redigo/redis"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
-//https://medium.com/@gilcrest_65433/basic-redis-examples-with-go-a3348a12878e
-func NewPool() *redis.Pool {
-	return &redis.Pool{
+/**
+ * Where and how to connect to redis, built from REDIS_URL or REDIS_HOST, REDIS_PORT...
+ */
+type redisTarget struct {
+	address  string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+	// Master name when the address is discovered with sentinel
+	master           string
+	sentinels        []string
+	sentinelPassword string
+	timeout          time.Duration
+}
+
+/**
+ * Create the redis pool, it's created once at startup and shared by the whole application.
+ * Connections idle for more than a minute are checked with a PING before being borrowed.
+ * With sentinel, the role of the connection is checked every time so a failover is followed.
+ * https://medium.com/@gilcrest_65433/basic-redis-examples-with-go-a3348a12878e
+ */
+func NewPool(config *Config) (*redis.Pool, error) {
+	target, err := newRedisTarget(config)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &redis.Pool{
 		// Maximum number of idle connections in the pool.
-		MaxIdle: 80,
+		MaxIdle: config.RedisMaxIdle,
 		// max number of connections
-		MaxActive: 12000,
-		// Dial is an application supplied function for creating and
-		// configuring a connection.
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", REDIS_HOST + ":" + REDIS_PORT)
-			if err != nil {
-				println("Error Redis Dial")
-				panic(err.Error())
+		MaxActive: config.RedisMaxActive,
+		// Close connections after remaining idle for this duration
+		IdleTimeout: time.Duration(config.RedisIdleTimeout) * time.Second,
+		// Wait for a connection to be returned instead of failing when MaxActive is reached
+		Wait: true,
+		Dial: target.dial,
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if target.master != "" {
+				return checkMaster(c)
 			}
-			if REDIS_PASSWORD != "" {
-				_, err2 := c.Do("AUTH", REDIS_PASSWORD)
-				if err2 != nil {
-					println("Error Redis AUTH")
-					panic(err2)
-				}
+			if time.Since(t) < time.Minute {
+				return nil
 			}
-			_, err3 := c.Do("SELECT", REDIS_DB)
-			if err3 != nil {
-				println("Error Redis DO SELECT")
-				panic(err3)
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+
+	c := pool.Get()
+	defer c.Close()
+	if _, err := c.Do("PING"); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	// Needed to clean the files when their key expire, set once instead of on every connection
+	if _, err := c.Do("CONFIG", "SET", "notify-keyspace-events", "KEA"); err != nil {
+		log.Warn("Can't set notify-keyspace-events, make sure it's set to KEA in the redis configuration : ", err)
+	}
+
+	return pool, nil
+}
+
+/**
+ * Read the redis connection settings, REDIS_URL takes precedence over REDIS_HOST, REDIS_PORT, REDIS_PASSWORD and REDIS_DB
+ */
+func newRedisTarget(config *Config) (*redisTarget, error) {
+	target := &redisTarget{
+		address:          net.JoinHostPort(config.RedisHost, strconv.Itoa(config.RedisPort)),
+		username:         config.RedisUsername,
+		password:         config.RedisPassword,
+		db:               config.RedisDb,
+		master:           config.RedisSentinelMaster,
+		sentinels:        config.RedisSentinelAddrs,
+		sentinelPassword: config.RedisSentinelPassword,
+		timeout:          time.Duration(config.RedisTimeout) * time.Second,
+	}
+	useTLS := config.RedisTls
+
+	if config.RedisUrl != "" {
+		u, err := url.Parse(config.RedisUrl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL : %w", err)
+		}
+		if u.Scheme != "redis" && u.Scheme != "rediss" {
+			return nil, fmt.Errorf("invalid REDIS_URL scheme %q, expected redis or rediss", u.Scheme)
+		}
+		useTLS = u.Scheme == "rediss"
+
+		host, port, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			host, port = u.Host, "6379"
+		}
+		if host == "" {
+			host = "localhost"
+		}
+		target.address = net.JoinHostPort(host, port)
+
+		if u.User != nil {
+			target.username = u.User.Username()
+			target.password, _ = u.User.Password()
+			// redis://:password@host has no user, redis://password@host is the old way without ACL
+			if _, isSet := u.User.Password(); !isSet {
+				target.username, target.password = "", u.User.Username()
 			}
+		}
 
-			_, err4 := c.Do("CONFIG", "SET", "notify-keyspace-events", "KEA")
-			if err4 != nil {
-				println("Error Redis CONFIG")
-				panic(err4)
+		if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+			target.db, err = strconv.Atoi(db)
+			if err != nil {
+				return nil, fmt.Errorf("invalid database %q in REDIS_URL", db)
 			}
-			return c, err
-		},
+		}
+	}
+
+	if useTLS {
+		target.tls = &tls.Config{InsecureSkipVerify: config.RedisTlsSkipVerify}
+		if config.RedisTlsCaCert != "" {
+			pem, err := ioutil.ReadFile(config.RedisTlsCaCert)
+			if err != nil {
+				return nil, fmt.Errorf("can't read REDIS_TLS_CA_CERT : %w", err)
+			}
+			target.tls.RootCAs = x509.NewCertPool()
+			if !target.tls.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in REDIS_TLS_CA_CERT %s", config.RedisTlsCaCert)
+			}
+		}
+	}
+
+	if target.master != "" && len(target.sentinels) == 0 {
+		return nil, errors.New("REDIS_SENTINEL_MASTER is set but REDIS_SENTINEL_ADDRS is empty")
 	}
+
+	return target, nil
 }
 
-// ping tests connectivity for redis (PONG should be returned)
-func Ping(c redis.Conn) bool {
-	// Send PING command to Redis
-	pong, err := redis.String(c.Do("PING"))
+func (t *redisTarget) options() []redis.DialOption {
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(t.timeout),
+		redis.DialReadTimeout(t.timeout),
+		redis.DialWriteTimeout(t.timeout),
+	}
+	if t.tls != nil {
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(t.tls))
+	}
+	return options
+}
+
+/**
+ * Dial is an application supplied function for creating and configuring a connection.
+ */
+func (t *redisTarget) dial() (redis.Conn, error) {
+	address := t.address
+	if t.master != "" {
+		var err error
+		address, err = t.masterAddress()
+		if err != nil {
+			log.Error("Error Redis sentinel : ", err)
+			return nil, err
+		}
+	}
+
+	c, err := redis.Dial("tcp", address, t.options()...)
 	if err != nil {
-		return false
+		log.Error("Error Redis Dial : ", err)
+		return nil, err
+	}
+	if err := auth(c, t.username, t.password); err != nil {
+		log.Error("Error Redis AUTH : ", err)
+		c.Close()
+		return nil, err
+	}
+	if _, err := c.Do("SELECT", t.db); err != nil {
+		log.Error("Error Redis DO SELECT : ", err)
+		c.Close()
+		return nil, err
+	}
+	if t.master != "" {
+		if err := checkMaster(c); err != nil {
+			log.Error("Error Redis sentinel : ", err)
+			c.Close()
+			return nil, err
+		}
 	}
+	return c, nil
+}
+
+/**
+ * Ask the sentinels, in order, the address of the master
+ */
+func (t *redisTarget) masterAddress() (string, error) {
+	for _, sentinel := range t.sentinels {
+		c, err := redis.Dial("tcp", sentinel,
+			redis.DialConnectTimeout(t.timeout),
+			redis.DialReadTimeout(t.timeout),
+			redis.DialWriteTimeout(t.timeout))
+		if err != nil {
+			log.Warn("Can't reach redis sentinel ", sentinel, " : ", err)
+			continue
+		}
 
-	log.Info("PING Response = %s\n", pong)
-	// Output: PONG
+		err = auth(c, "", t.sentinelPassword)
+		var reply []string
+		if err == nil {
+			reply, err = redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", t.master))
+		}
+		c.Close()
+		if err != nil || len(reply) != 2 {
+			log.Warn("Redis sentinel ", sentinel, " doesn't know the master ", t.master, " : ", err)
+			continue
+		}
+		return net.JoinHostPort(reply[0], reply[1]), nil
+	}
+	return "", fmt.Errorf("no sentinel gave the address of the master %s", t.master)
+}
 
-	if pong == "PONG" {
-		return true
+func auth(c redis.Conn, username, password string) error {
+	if password == "" {
+		return nil
+	}
+	var err error
+	if username != "" {
+		_, err = c.Do("AUTH", username, password)
 	} else {
-		return false
+		_, err = c.Do("AUTH", password)
+	}
+	return err
+}
+
+/**
+ * Make sure the connection is still on the master, after a failover the old master becomes a replica
+ */
+func checkMaster(c redis.Conn) error {
+	role, err := redis.Values(c.Do("ROLE"))
+	if err != nil {
+		return err
 	}
-}
\ No newline at end of file
+	if len(role) == 0 {
+		return errors.New("empty ROLE reply")
+	}
+	if name, _ := redis.String(role[0], nil); name != "master" {
+		return fmt.Errorf("connected to a %s instead of the master", name)
+	}
+	return nil
+}
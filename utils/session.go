@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/eraffaelli/Okuru/models"
+	"github.com/labstack/echo"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	SessionCookie = "okuru_session"
+	loginCookie   = "okuru_login"
+)
+
+var ErrInvalidCookie = errors.New("invalid or expired cookie")
+
+/**
+ * Encode value in a cookie value signed with OKURU_SESSION_SECRET, value must have an "exp" unix time.
+ */
+func signCookie(config *Config, value interface{}) (string, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(cookieMac(config, encoded)), nil
+}
+
+/**
+ * Check the signature and the expiration of a cookie value made by signCookie and decode it in dest
+ */
+func verifyCookie(config *Config, raw string, dest interface{}) error {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 2 {
+		return ErrInvalidCookie
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, cookieMac(config, parts[0])) {
+		return ErrInvalidCookie
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidCookie
+	}
+
+	var expire struct {
+		Expire int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &expire) != nil || time.Now().Unix() >= expire.Expire {
+		return ErrInvalidCookie
+	}
+	return json.Unmarshal(payload, dest)
+}
+
+/**
+ * Random url safe token, for the login state and the session secret
+ */
+func RandomToken() string {
+	b := make([]byte, 32)
+	if _, err := cryptorand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func cookieMac(config *Config, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(config.SessionSecret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func newCookie(config *Config, name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   !config.NoSsl,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+/**
+ * Start the session of a logged in user, it lasts OKURU_SESSION_DURATION
+ */
+func SetSession(context echo.Context, u *models.User) error {
+	config := GetConfig(context)
+	u.Expire = time.Now().Add(time.Duration(config.SessionDuration) * time.Second).Unix()
+	value, err := signCookie(config, u)
+	if err != nil {
+		return err
+	}
+	context.SetCookie(newCookie(config, SessionCookie, value, config.SessionDuration))
+	return nil
+}
+
+/**
+ * Return the logged in user, nil if there's no valid session
+ */
+func GetSession(context echo.Context) *models.User {
+	cookie, err := context.Cookie(SessionCookie)
+	if err != nil {
+		return nil
+	}
+	u := new(models.User)
+	if verifyCookie(GetConfig(context), cookie.Value, u) != nil {
+		return nil
+	}
+	return u
+}
+
+func ClearSession(context echo.Context) {
+	context.SetCookie(newCookie(GetConfig(context), SessionCookie, "", -1))
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"github.com/eraffaelli/Okuru/blob"
+	"github.com/eraffaelli/Okuru/store"
+	"path/filepath"
+	"time"
+)
+
+/**
+ * Services of a server, opened from its configuration by OpenServices and carried by it in Config.Services.
+ * OIDC and Limiter are nil when the login or the rate limits aren't enabled.
+ */
+type Services struct {
+	// Store of the passwords and files metadata
+	Store store.Store
+	// Storage of the files content
+	Blobs blob.Storage
+	// OpenID Connect login of the creation pages
+	OIDC *OIDCAuth
+	// Limiter of the rate limit middlewares
+	Limiter Limiter
+}
+
+/**
+ * Open the store, the blob storage, the OpenID Connect provider and the rate limiter of the configuration
+ */
+func OpenServices(config *Config) (Services, error) {
+	var s Services
+	var err error
+	s.Store, err = NewStore(config)
+	if err != nil {
+		return s, fmt.Errorf("store problem : %w", err)
+	}
+	if err = s.Store.Ping(); err != nil {
+		s.Store.Close()
+		return s, fmt.Errorf("store problem : %w", err)
+	}
+	s.Blobs, err = NewBlobStorage(config)
+	if err != nil {
+		s.Store.Close()
+		return s, fmt.Errorf("blob storage problem : %w", err)
+	}
+	s.OIDC, err = NewOIDC(context.Background(), config)
+	if err != nil {
+		s.Store.Close()
+		return s, fmt.Errorf("OpenID Connect problem : %w", err)
+	}
+	s.Limiter, err = NewLimiter(config, s.Store)
+	if err != nil {
+		s.Store.Close()
+		return s, fmt.Errorf("rate limiter problem : %w", err)
+	}
+	return s, nil
+}
+
+/**
+ * Close the rate limiter then the store
+ */
+func (s Services) Close() error {
+	if s.Limiter != nil {
+		if err := s.Limiter.Close(); err != nil {
+			return err
+		}
+	}
+	return s.Store.Close()
+}
+
+/**
+ * Create the store selected with OKURU_STORE
+ */
+func NewStore(config *Config) (store.Store, error) {
+	switch config.Store {
+	case "redis":
+		pool, err := NewPool(config)
+		if err != nil {
+			return nil, err
+		}
+		return store.NewRedis(pool, config.RedisPrefix), nil
+	case "memory":
+		return store.NewMemory(), nil
+	case "bolt":
+		path, err := filepath.Abs(config.BoltPath)
+		if err != nil {
+			return nil, err
+		}
+		return store.NewBolt(path, time.Duration(config.BoltSweepInterval)*time.Second)
+	}
+	return nil, fmt.Errorf("unknown store %q, expected redis, memory or bolt", config.Store)
+}
+
+/**
+ * Create the limiter selected with OKURU_RATE_LIMIT_STORE, nil if OKURU_RATE_LIMIT is false.
+ * The redis limiter uses the connections of the redis store s.
+ */
+func NewLimiter(config *Config, s store.Store) (Limiter, error) {
+	if !config.RateLimit {
+		return nil, nil
+	}
+	switch config.RateLimitStore {
+	case "memory":
+		return NewMemoryLimiter(), nil
+	case "redis":
+		redisStore, ok := s.(*store.Redis)
+		if !ok {
+			return nil, fmt.Errorf("the redis rate limit store needs the redis store")
+		}
+		return NewRedisLimiter(redisStore.Pool(), config.RedisPrefix+"ratelimit_"), nil
+	}
+	return nil, fmt.Errorf("unknown rate limit store %q, expected memory or redis", config.RateLimitStore)
+}
+
+/**
+ * Create the blob storage selected with OKURU_BLOB_STORAGE
+ */
+func NewBlobStorage(config *Config) (blob.Storage, error) {
+	switch config.BlobStorage {
+	case "local":
+		folder, err := filepath.Abs(config.FileFolder)
+		if err != nil {
+			return nil, err
+		}
+		return blob.NewLocal(folder), nil
+	case "s3":
+		s3, err := blob.NewS3(blob.S3Options{
+			Endpoint:  config.S3Endpoint,
+			Bucket:    config.S3Bucket,
+			AccessKey: config.S3AccessKey,
+			SecretKey: config.S3SecretKey,
+			Region:    config.S3Region,
+			UseSSL:    config.S3UseSsl,
+			PathStyle: config.S3PathStyle,
+		})
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s3.Ping(ctx); err != nil {
+			return nil, fmt.Errorf("s3 bucket %s not reachable : %w", config.S3Bucket, err)
+		}
+		return s3, nil
+	}
+	return nil, fmt.Errorf("unknown blob storage %q, expected local or s3", config.BlobStorage)
+}
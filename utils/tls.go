@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/**
+ * True when Okuru serves https itself, from certificate files or ACME
+ */
+func (c *Config) TlsEnabled() bool {
+	return c.TlsCert != "" || len(c.AcmeDomains) > 0
+}
+
+/**
+ * TLS configuration of the server, nil when it serves plain http. The handler is served on the http port,
+ * it answers the ACME http challenges and redirects everything else to https.
+ */
+func NewTLS(config *Config) (*tls.Config, http.Handler, error) {
+	redirect := redirectHandler(config.AppPort)
+	if config.TlsCert != "" {
+		certificate, err := tls.LoadX509KeyPair(config.TlsCert, config.TlsKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't load OKURU_TLS_CERT and OKURU_TLS_KEY : %w", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}, redirect, nil
+	}
+	if len(config.AcmeDomains) == 0 {
+		return nil, nil, nil
+	}
+
+	client := &acme.Client{DirectoryURL: config.AcmeDirectory}
+	if config.AcmeCaCert != "" {
+		pem, err := ioutil.ReadFile(config.AcmeCaCert)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't read OKURU_ACME_CA_CERT : %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificate found in OKURU_ACME_CA_CERT %s", config.AcmeCaCert)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(config.AcmeDomains...),
+		Cache:      autocert.DirCache(config.AcmeCacheDir),
+		Email:      config.AcmeEmail,
+		Client:     client,
+	}
+	// Also answers the tls-alpn-01 challenges, so the http port isn't needed to get a certificate
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	return tlsConfig, manager.HTTPHandler(redirect), nil
+}
+
+/**
+ * Redirect the http requests to the same url in https on port
+ */
+func redirectHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		// 308 keeps the method and the body of the API calls
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		port     int
+		method   string
+		host     string
+		target   string
+		code     int
+		location string
+	}{
+		{443, http.MethodGet, "example.com", "/", http.StatusMovedPermanently, "https://example.com/"},
+		{443, http.MethodGet, "example.com:80", "/file?x=1", http.StatusMovedPermanently, "https://example.com/file?x=1"},
+		{443, http.MethodHead, "example.com", "/", http.StatusMovedPermanently, "https://example.com/"},
+		{443, http.MethodPost, "example.com", "/api/v1", http.StatusPermanentRedirect, "https://example.com/api/v1"},
+		{443, http.MethodDelete, "example.com:8080", "/api/v1/key", http.StatusPermanentRedirect, "https://example.com/api/v1/key"},
+		{8443, http.MethodGet, "example.com:8080", "/", http.StatusMovedPermanently, "https://example.com:8443/"},
+		{8443, http.MethodPost, "example.com", "/api/v1", http.StatusPermanentRedirect, "https://example.com:8443/api/v1"},
+		{443, http.MethodGet, "[2001:db8::1]:80", "/", http.StatusMovedPermanently, "https://[2001:db8::1]/"},
+		{443, http.MethodGet, "[2001:db8::1]", "/", http.StatusMovedPermanently, "https://[2001:db8::1]/"},
+		{8443, http.MethodGet, "[2001:db8::1]:80", "/file", http.StatusMovedPermanently, "https://[2001:db8::1]:8443/file"},
+		{8443, http.MethodPut, "[2001:db8::1]", "/", http.StatusPermanentRedirect, "https://[2001:db8::1]:8443/"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.target, nil)
+		request.Host = test.host
+		recorder := httptest.NewRecorder()
+		redirectHandler(test.port).ServeHTTP(recorder, request)
+		if recorder.Code != test.code || recorder.Header().Get("Location") != test.location {
+			t.Errorf("%s %s%s on port %d = %d to %q, want %d to %q", test.method, test.host, test.target, test.port,
+				recorder.Code, recorder.Header().Get("Location"), test.code, test.location)
+		}
+	}
+}
+
+func TestNewTLSWithoutHttps(t *testing.T) {
+	tlsConfig, redirect, err := NewTLS(DefaultConfig())
+	if tlsConfig != nil || redirect != nil || err != nil {
+		t.Errorf("NewTLS without certificate = %v, %v, %v, want nothing", tlsConfig, redirect, err)
+	}
+
+	config := DefaultConfig()
+	config.TlsCert = "missing.pem"
+	config.TlsKey = "missing.key"
+	if _, _, err := NewTLS(config); err == nil {
+		t.Error("NewTLS with missing certificate files didn't fail")
+	}
+}
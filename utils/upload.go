@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"github.com/eraffaelli/Okuru/models"
+	"github.com/eraffaelli/Okuru/store"
+	"github.com/fernet/fernet-go"
+	"github.com/google/uuid"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+ * Resumable uploads are assembled in the upload folder, their state is kept in the store under "upload_" + id
+ * and forgotten after upload_expiration. Once the last chunk is received the file becomes a regular file share.
+ * The password and the webhook secret of the share are encrypted with the upload key, it's only given to the uploader
+ * in the upload url (id + separator + key), so they can only be read back by a request to that url.
+ */
+
+// Chunks of the same upload are written one at a time, it's only enforced within this process
+var uploadLocks sync.Map
+
+func uploadPath(config *Config, uploadId string) string {
+	return filepath.Join(config.UploadDir(), filepath.Base(uploadId))
+}
+
+/**
+ * Lock the upload until the returned function is called.
+ * @param uploadId
+ */
+func LockUpload(uploadId string) func() {
+	mu, _ := uploadLocks.LoadOrStore(uploadId, new(sync.Mutex))
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+/**
+ * Save a new upload with an empty staging file, u.UploadId and u.UploadKey are set.
+ */
+func SetUpload(config *Config, u *models.Upload) *echo.HTTPError {
+	var k fernet.Key
+	if err := k.Generate(); err != nil {
+		log.Errorf("SetUpload() Generate err : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	password, err := fernet.EncryptAndSign([]byte(u.Password), &k)
+	if err != nil {
+		log.Errorf("SetUpload() EncryptAndSign err : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	webhookSecret, err := fernet.EncryptAndSign([]byte(u.WebhookSecret), &k)
+	if err != nil {
+		log.Errorf("SetUpload() EncryptAndSign err : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	u.UploadId = uuid.New().String()
+	u.UploadKey = k.Encode()
+	u.Offset = 0
+
+	if err := os.MkdirAll(config.UploadDir(), 0700); err != nil {
+		log.Errorf("SetUpload() err mkdir : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	staging, err := os.OpenFile(uploadPath(config, u.UploadId), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Errorf("SetUpload() err create staging file : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	staging.Close()
+
+	err = config.Services.Store.Put("upload_"+u.UploadId, store.Fields{
+		"length":         strconv.FormatInt(u.Length, 10),
+		"offset":         "0",
+		"filename":       u.FileName,
+		"password":       string(password),
+		"ttl":            strconv.Itoa(u.TTL),
+		"views":          strconv.Itoa(u.Views),
+		"deletable":      strconv.FormatBool(u.Deletable),
+		"max_attempts":   strconv.Itoa(u.MaxAttempts),
+		"allowed_ips":    u.AllowedIps,
+		"webhook_url":    u.WebhookUrl,
+		"webhook_secret": string(webhookSecret),
+	}, config.UploadExpiration)
+	if err != nil {
+		log.Errorf("SetUpload() Store err put : %+v", err)
+		CleanUpload(config, u.UploadId)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+/**
+ * Load the upload u.UploadId and decrypt its password and webhook secret with u.UploadKey, a wrong key is a 404.
+ */
+func GetUpload(config *Config, u *models.Upload) *echo.HTTPError {
+	k, err := fernet.DecodeKey(u.UploadKey)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+
+	fields, _, err := config.Services.Store.Get("upload_" + u.UploadId)
+	if err != nil {
+		return storeError("GetUpload", err)
+	}
+
+	err = fields.Scan(u)
+	if err != nil {
+		log.Errorf("GetUpload(config, ) err scan struct : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	password := fernet.VerifyAndDecrypt([]byte(u.Password), 0, []*fernet.Key{k})
+	webhookSecret := fernet.VerifyAndDecrypt([]byte(u.WebhookSecret), 0, []*fernet.Key{k})
+	if password == nil || webhookSecret == nil {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	u.Password = string(password)
+	u.WebhookSecret = string(webhookSecret)
+	return nil
+}
+
+/**
+ * Append chunk to the staging file at u.Offset, never past u.Length.
+ * What was received is kept even if the connection breaks, so the client can resume from the new offset.
+ * The upload must be locked with LockUpload.
+ */
+func WriteUpload(config *Config, u *models.Upload, chunk io.Reader) *echo.HTTPError {
+	staging, err := os.OpenFile(uploadPath(config, u.UploadId), os.O_WRONLY, 0600)
+	if err != nil {
+		log.Errorf("WriteUpload() err open staging file : %+v", err)
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	defer staging.Close()
+
+	// Drop anything written after the saved offset by a chunk that failed
+	if err := staging.Truncate(u.Offset); err != nil {
+		log.Errorf("WriteUpload() err truncate : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	if _, err := staging.Seek(u.Offset, io.SeekStart); err != nil {
+		log.Errorf("WriteUpload() err seek : %+v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	n, copyErr := io.Copy(staging, io.LimitReader(chunk, u.Length-u.Offset))
+	if err := staging.Sync(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	u.Offset += n
+
+	err = config.Services.Store.Update("upload_"+u.UploadId, store.Fields{"offset": strconv.FormatInt(u.Offset, 10)})
+	if err != nil {
+		return storeError("WriteUpload", err)
+	}
+	if copyErr != nil {
+		log.Errorf("WriteUpload() err copy chunk : %+v", copyErr)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return nil
+}
+
+/**
+ * Turn a complete upload into a file share with the ttl, views and deletable of the upload.
+ * Returns the file token and the password token if a password was provided.
+ * The upload must be locked with LockUpload, it's removed once done.
+ */
+func CompleteUpload(config *Config, u *models.Upload) (string, string, *echo.HTTPError) {
+	token, err := NewFileToken(config)
+	if err != nil {
+		return "", "", echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	staging, err := os.Open(uploadPath(config, u.UploadId))
+	if err != nil {
+		log.Errorf("CompleteUpload() err open staging file : %+v", err)
+		return "", "", echo.NewHTTPError(http.StatusNotFound)
+	}
+	err2 := ArchiveFile(config, staging, u.FileName, token)
+	staging.Close()
+	if err2 != nil {
+		return "", "", err2
+	}
+
+	var provided = false
+	var passwordToken, providedKey string
+	password := u.Password
+	if len(password) == 0 {
+		password = RandomSequence(50)
+	} else {
+		provided = true
+		passwordToken, err2 = SetPassword(config, password, u.TTL, u.Views, false, "", 0, u.AllowedIps)
+		if err2 != nil {
+			CleanFile(config, strings.Split(token, config.TokenSeparator)[0])
+			return "", "", err2
+		}
+		providedKey = strings.Split(passwordToken, config.TokenSeparator)[0]
+	}
+
+	err2 = SetFile(config, token, password, u.TTL, u.Views, u.Deletable, provided, providedKey, u.MaxAttempts, u.AllowedIps)
+	if err2 != nil {
+		CleanFile(config, strings.Split(token, config.TokenSeparator)[0])
+		if providedKey != "" {
+			config.Services.Store.Delete(providedKey)
+		}
+		return "", "", err2
+	}
+
+	SetWebhook(config, "file_"+strings.Split(token, config.TokenSeparator)[0], u.WebhookUrl, u.WebhookSecret, u.TTL)
+	RemoveUpload(config, u.UploadId)
+	return token, passwordToken, nil
+}
+
+/**
+ * Forget the upload and remove its staging file
+ */
+func RemoveUpload(config *Config, uploadId string) {
+	err := config.Services.Store.Delete("upload_" + uploadId)
+	if err != nil {
+		log.Errorf("RemoveUpload() Store err DEL : %+v", err)
+	}
+	CleanUpload(config, uploadId)
+}
+
+func CleanUpload(config *Config, uploadId string) {
+	log.Debugf("CleanUpload uploadId : %s", uploadId)
+	err := os.Remove(uploadPath(config, uploadId))
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Delete upload remove error : %+v", err)
+	}
+	uploadLocks.Delete(uploadId)
+}
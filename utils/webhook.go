@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eraffaelli/Okuru/models"
+	"github.com/eraffaelli/Okuru/store"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Events sent to the webhooks
+const (
+	EventRevealed   = "revealed"
+	EventDownloaded = "downloaded"
+	EventDeleted    = "deleted"
+	EventExpired    = "expired"
+)
+
+// Seconds the webhook of a share is kept after the share expires, so it's still known when the expiration is notified
+const webhookGrace = 3600
+
+// First wait before retrying a failed delivery, doubled on each retry
+const webhookBackoff = time.Second
+
+// Time given to a webhook to answer
+const webhookTimeout = 10 * time.Second
+
+// Deliveries in progress, waited for on shutdown
+var Deliveries sync.WaitGroup
+
+// Cancelled on shutdown by StopDeliveries, the deliveries waiting for a retry give up
+var deliveriesContext, StopDeliveries = context.WithCancel(context.Background())
+
+var errWebhookRefused = errors.New("loopback, private and link-local addresses aren't in OKURU_WEBHOOK_ALLOWED_NETWORKS")
+
+/**
+ * Check the webhook url given at the creation of a share, empty when the share has none
+ */
+func ParseWebhookUrl(config *Config, value string) (string, *echo.HTTPError) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	if !config.ShareWebhooks {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "Webhooks aren't enabled on this server")
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "The webhook must be an http or https url")
+	}
+	return u.String(), nil
+}
+
+/**
+ * Keep the webhook of the share stored under key, next to it so it outlives the share and its expiration can be sent.
+ * Nothing is kept when neither the share nor OKURU_WEBHOOK_URL have a webhook.
+ * Unlike the secrets of the share, its webhook secret is stored in clear: the expiration is sent when no request
+ * brings the key of the link, it couldn't be decrypted then. It only signs the events, it gives no access to the share.
+ * @param {string} key of the share in the store
+ * @param {string} webhookUrl from ParseWebhookUrl (optional)
+ * @param {string} secret signing the events of the share, defaults to OKURU_WEBHOOK_SECRET (optional)
+ * @param {number} ttl of the share
+ */
+func SetWebhook(config *Config, key string, webhookUrl string, secret string, ttl int) {
+	if webhookUrl == "" && config.WebhookUrl == "" {
+		return
+	}
+	err := config.Services.Store.Put("webhook_"+key, store.Fields{
+		"url":    webhookUrl,
+		"secret": secret,
+		"sent":   "0",
+	}, ttl+webhookGrace)
+	if err != nil {
+		log.Errorf("SetWebhook() Store err put : %+v", err)
+	}
+}
+
+/**
+ * Send event to the webhooks of the share stored under key. With last the share is gone and its webhook is removed,
+ * it's claimed first so the event is sent once even when several instances are notified of the expiration.
+ */
+func ShareEvent(config *Config, key string, event string, viewsLeft int, last bool) {
+	if last {
+		sent, err := config.Services.Store.Increment("webhook_"+key, "sent")
+		if err != nil || sent != 1 {
+			if err != nil && err != store.ErrNotFound {
+				log.Errorf("ShareEvent() Store err increment : %+v", err)
+			}
+			return
+		}
+	}
+
+	fields, _, err := config.Services.Store.Get("webhook_" + key)
+	if err == store.ErrNotFound {
+		return
+	}
+	if err != nil {
+		log.Errorf("ShareEvent() Store err get : %+v", err)
+		return
+	}
+	w := new(models.Webhook)
+	if err := fields.Scan(w); err != nil {
+		log.Errorf("ShareEvent() err scan struct : %+v", err)
+		return
+	}
+	if last {
+		if err := config.Services.Store.Delete("webhook_" + key); err != nil {
+			log.Errorf("ShareEvent() Store err DEL : %+v", err)
+		}
+	}
+
+	e := &models.Event{
+		Event:     event,
+		Type:      "password",
+		Id:        key,
+		ViewsLeft: viewsLeft,
+		Time:      time.Now().UTC().Format(time.RFC3339),
+	}
+	if strings.HasPrefix(key, "file_") {
+		e.Type = "file"
+		e.Id = strings.TrimPrefix(key, "file_")
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("ShareEvent() err marshal : %+v", err)
+		return
+	}
+
+	if config.WebhookUrl != "" {
+		deliver(config, config.WebhookUrl, config.WebhookSecret, event, body)
+	}
+	if w.Url != "" {
+		if w.Secret == "" {
+			w.Secret = config.WebhookSecret
+		}
+		deliver(config, w.Url, w.Secret, event, body)
+	}
+}
+
+/**
+ * Send the event in the background, retried OKURU_WEBHOOK_RETRIES times with an exponential backoff.
+ * A webhook on a refused address isn't retried, and the retries stop with StopDeliveries.
+ */
+func deliver(config *Config, webhookUrl string, secret string, event string, body []byte) {
+	client := webhookClient(config)
+	Deliveries.Add(1)
+	go func() {
+		defer Deliveries.Done()
+		wait := webhookBackoff
+		for attempt := 0; ; attempt++ {
+			err := post(client, webhookUrl, secret, event, body)
+			if err == nil {
+				return
+			}
+			if attempt >= config.WebhookRetries || errors.Is(err, errWebhookRefused) {
+				log.WithField("webhook", webhookUrl).WithField("event", event).Error("Webhook delivery failed : ", err)
+				return
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-deliveriesContext.Done():
+				timer.Stop()
+				log.WithField("webhook", webhookUrl).WithField("event", event).Error("Webhook delivery abandoned on shutdown : ", err)
+				return
+			}
+			wait *= 2
+		}
+	}()
+}
+
+/**
+ * Client of the webhooks. The urls are chosen by the users, so the connections to loopback, private and link-local
+ * addresses are refused unless they are in OKURU_WEBHOOK_ALLOWED_NETWORKS. The address is checked once resolved, and
+ * again on each redirection. No proxy is used, it would hide the address.
+ */
+func webhookClient(config *Config) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || (internalIp(ip) && !config.webhookAllowed(ip)) {
+				return fmt.Errorf("webhook address %s refused, %w", host, errWebhookRefused)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			DisableKeepAlives:   true,
+		},
+	}
+}
+
+func internalIp(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+/**
+ * Post the event once. The body is signed with the secret in Okuru-Signature: sha256=hex(hmac(timestamp + "." + body)),
+ * with the timestamp sent in Okuru-Timestamp so the receiver can refuse old events.
+ */
+func post(client *http.Client, webhookUrl string, secret string, event string, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, webhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set("User-Agent", "Okuru-Webhook")
+	request.Header.Set("Okuru-Event", event)
+	request.Header.Set("Okuru-Timestamp", timestamp)
+	if secret != "" {
+		request.Header.Set("Okuru-Signature", "sha256="+SignWebhook(secret, timestamp, body))
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return nil
+}
+
+/**
+ * Signature of an event, as the receiver must compute it to check Okuru-Signature
+ */
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/eraffaelli/Okuru/store"
+	"github.com/google/uuid"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInternalIp(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1":        true,
+		"::1":              true,
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true,
+		"fe80::1":          true,
+		"fd00::1":          true,
+		"0.0.0.0":          true,
+		"::ffff:127.0.0.1": true,
+		"224.0.0.1":        true,
+		"192.0.2.1":        false,
+		"8.8.8.8":          false,
+		"2001:db8::1":      false,
+	}
+	for ip, want := range tests {
+		if got := internalIp(net.ParseIP(ip)); got != want {
+			t.Errorf("internalIp(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestWebhookClient(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		if r.Header.Get("Okuru-Signature") != "sha256="+SignWebhook("secret", r.Header.Get("Okuru-Timestamp"), []byte(`{}`)) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	err := post(webhookClient(config), server.URL, "secret", EventRevealed, []byte(`{}`))
+	if !errors.Is(err, errWebhookRefused) || received.Load() != 0 {
+		t.Fatalf("post to the loopback err = %v, want errWebhookRefused and no request", err)
+	}
+
+	config.WebhookAllowedNetworks = []string{"127.0.0.1"}
+	if err := post(webhookClient(config), server.URL, "secret", EventRevealed, []byte(`{}`)); err != nil {
+		t.Fatalf("post to an allowed network err = %v", err)
+	}
+
+	// A redirection can't lead out of the allowed networks
+	redirect := httptest.NewServer(http.RedirectHandler("http://127.0.0.2:1/", http.StatusTemporaryRedirect))
+	defer redirect.Close()
+	err = post(webhookClient(config), redirect.URL, "secret", EventRevealed, []byte(`{}`))
+	if !errors.Is(err, errWebhookRefused) {
+		t.Errorf("post redirected to a refused address err = %v, want errWebhookRefused", err)
+	}
+	if received.Load() != 1 {
+		t.Errorf("webhook got %d requests, want 1", received.Load())
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if received.Add(1) < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.WebhookAllowedNetworks = []string{"127.0.0.0/8"}
+	deliver(config, server.URL, "", EventRevealed, []byte(`{}`))
+	Deliveries.Wait()
+	if received.Load() != 2 {
+		t.Errorf("webhook got %d requests, want a failure and a retry", received.Load())
+	}
+
+	// A refused address isn't retried
+	config.WebhookAllowedNetworks = nil
+	start := time.Now()
+	deliver(config, server.URL, "", EventRevealed, []byte(`{}`))
+	Deliveries.Wait()
+	if time.Since(start) >= webhookBackoff || received.Load() != 2 {
+		t.Errorf("delivery to a refused address took %v and sent %d requests", time.Since(start), received.Load()-2)
+	}
+}
+
+func TestDeliverStopsOnShutdown(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	defer func(ctx context.Context, stop context.CancelFunc) {
+		deliveriesContext, StopDeliveries = ctx, stop
+	}(deliveriesContext, StopDeliveries)
+	deliveriesContext, StopDeliveries = context.WithCancel(context.Background())
+
+	config := DefaultConfig()
+	config.WebhookAllowedNetworks = []string{"127.0.0.0/8"}
+	config.WebhookRetries = 20
+	deliver(config, server.URL, "", EventRevealed, []byte(`{}`))
+	for received.Load() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	StopDeliveries()
+	Deliveries.Wait()
+	if time.Since(start) >= webhookBackoff {
+		t.Errorf("the delivery stopped %v after StopDeliveries", time.Since(start))
+	}
+	if received.Load() != 1 {
+		t.Errorf("webhook got %d requests, want no retry after StopDeliveries", received.Load())
+	}
+}
+
+func TestExpiredNotifiesShares(t *testing.T) {
+	received := make(chan string, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e struct {
+			Id string `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&e)
+		received <- e.Id
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.WebhookAllowedNetworks = []string{"127.0.0.0/8"}
+	config.Services.Store = store.NewMemory()
+	password := uuid.New().String()
+	for _, key := range []string{password, "apikey_" + password, "ratelimit_reveal_192.0.2.1", "session"} {
+		SetWebhook(config, key, server.URL, "", 60)
+		expired(config, key)
+	}
+	Deliveries.Wait()
+
+	close(received)
+	var ids []string
+	for id := range received {
+		ids = append(ids, id)
+	}
+	if len(ids) != 1 || ids[0] != password {
+		t.Errorf("expired notified %v, want only the password share %s", ids, password)
+	}
+}